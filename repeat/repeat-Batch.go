@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BatchSize Size of a rolling batch, as a node count or a percentage of all
+// matched nodes
+type BatchSize struct {
+	Count   int
+	Percent float64
+}
+
+// ParseBatchSizes Parse a comma-separated list of batch sizes such as
+// 1,10%,100%
+func ParseBatchSizes(s string) ([]BatchSize, error) {
+	var sizes []BatchSize
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+
+		if strings.HasSuffix(item, "%") {
+			p, err := strconv.ParseFloat(strings.TrimSuffix(item, "%"), 64)
+			if err != nil || p <= 0 || p > 100 {
+				return nil, fmt.Errorf("Invalid batch size %q", item)
+			}
+			sizes = append(sizes, BatchSize{Percent: p})
+			continue
+		}
+
+		c, err := strconv.Atoi(item)
+		if err != nil || c < 1 {
+			return nil, fmt.Errorf("Invalid batch size %q", item)
+		}
+		sizes = append(sizes, BatchSize{Count: c})
+	}
+
+	return sizes, nil
+}
+
+// SplitBatches Split nodes into consecutive batches of the given sizes. The
+// last size repeats until all nodes are batched, and percentages are of the
+// total node count, rounded up.
+func SplitBatches(nodes []Node, sizes []BatchSize) [][]Node {
+	var batches [][]Node
+	total := len(nodes)
+
+	for index := 0; len(nodes) > 0; index++ {
+		size := sizes[len(sizes)-1]
+		if index < len(sizes) {
+			size = sizes[index]
+		}
+
+		count := size.Count
+		if size.Percent > 0 {
+			count = int(math.Ceil(float64(total) * size.Percent / 100))
+		}
+		if count < 1 {
+			count = 1
+		}
+		if count > len(nodes) {
+			count = len(nodes)
+		}
+
+		batches = append(batches, nodes[:count])
+		nodes = nodes[count:]
+	}
+
+	return batches
+}
+
+// RunBatches Submit batches to the scheduler one at a time, waiting for each
+// to finish, then pausing and running the health check before the next. The
+// scheduler is stopped when the health check fails, so later batches are
+// skipped.
+func RunBatches(ctx context.Context, batches [][]Node, s *Scheduler, pause time.Duration, check string, l *log.Logger) {
+	for index, batch := range batches {
+		if s.Stopped() { // Submit remaining nodes to be recorded as skipped
+			for _, n := range batch {
+				s.Submit(n)
+			}
+			continue
+		}
+
+		l.Printf("BATCH %d/%d starting %d nodes\n", index+1, len(batches), len(batch))
+
+		for _, n := range batch {
+			s.Submit(n)
+		}
+		s.Drain()
+
+		if index == len(batches)-1 || s.Stopped() {
+			continue
+		}
+
+		if pause > 0 {
+			l.Printf("BATCH %d/%d done, pausing %v\n", index+1, len(batches), pause)
+			select {
+			case <-ctx.Done():
+			case <-time.After(pause):
+			}
+		}
+
+		if check != "" && !s.Stopped() {
+			output, err := shellCommand(ctx, check).CombinedOutput()
+			if err != nil {
+				l.Printf("ERROR Batch health check failed, no further nodes will be started: %v: %v\n", err, strings.TrimSpace(string(output)))
+				s.Stop()
+			}
+		}
+	}
+}
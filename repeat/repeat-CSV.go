@@ -2,24 +2,125 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
 	"log"
-	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
-// ParseCSV Parse CSV file writing found nodes to chan
-func ParseCSV(path string, ch chan<- Node, l *log.Logger) {
-	defer close(ch)
+// CSVOptions Dialect of CSV inventories
+type CSVOptions struct {
+	Delimiter  rune                 // Field delimiter
+	Comment    rune                 // Lines starting with this are ignored, 0 for none
+	NoHeader   bool                 // No header row, columns are named col1, col2, ...
+	LazyQuotes bool                 // Allow quotes in unquoted fields and unescaped quotes in quoted fields
+	Schema     map[string]CSVColumn // Column types by name, string for others
+}
+
+// CSVColumn Type of a CSV column
+type CSVColumn struct {
+	Type      string // string, int, float, bool or list
+	Separator string // Item separator for list columns
+}
+
+// CSVDialect Dialect of CSV inventories
+var CSVDialect = CSVOptions{Delimiter: ','}
+
+// ParseCSVDelimiter Return the delimiter for -csv-delimiter, a single
+// character or tab
+func ParseCSVDelimiter(s string) (rune, error) {
+	switch s {
+	case "tab", `\t`:
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("Invalid delimiter %q", s)
+	}
+	return r, nil
+}
+
+// ParseCSVSchema Parse comma-separated NAME:TYPE column types, where TYPE is
+// string, int, float, bool or list, optionally followed by :SEPARATOR for
+// list items (default ;)
+func ParseCSVSchema(s string) (map[string]CSVColumn, error) {
+	schema := make(map[string]CSVColumn)
+	if s == "" {
+		return schema, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid column type %q, expected NAME:TYPE", item)
+		}
+
+		column := CSVColumn{Type: parts[1], Separator: ";"}
+		switch column.Type {
+		case "string", "int", "float", "bool":
+			if len(parts) == 3 {
+				return nil, fmt.Errorf("Invalid column type %q, only list takes a separator", item)
+			}
+		case "list":
+			if len(parts) == 3 && parts[2] != "" {
+				column.Separator = parts[2]
+			}
+		default:
+			return nil, fmt.Errorf("Unknown column type %q", column.Type)
+		}
+		schema[parts[0]] = column
+	}
+
+	return schema, nil
+}
+
+// value Convert a field to the column's type. Empty typed fields are nil.
+func (c CSVColumn) value(s string) (interface{}, error) {
+	if s == "" && c.Type != "string" && c.Type != "" {
+		return nil, nil
+	}
+
+	var v interface{}
+	var err error
+	switch c.Type {
+	case "int":
+		v, err = strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	case "float":
+		v, err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+	case "bool":
+		v, err = strconv.ParseBool(strings.TrimSpace(s))
+	case "list":
+		items := make([]interface{}, 0)
+		for _, item := range strings.Split(s, c.Separator) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	default:
+		return s, nil
+	}
 
-	f, err := os.Open(path)
 	if err != nil {
-		l.Printf("ERROR %v: %v\n", path, err)
-		return
+		return nil, fmt.Errorf("Invalid %v %q", c.Type, s)
 	}
-	defer f.Close()
+	return v, nil
+}
+
+// ParseCSV Parse CSV inventory read from f writing found nodes to chan. Rows
+// which cannot be read are reported and skipped.
+func ParseCSV(path string, f io.Reader, ch chan<- Node, l *log.Logger) {
+	defer close(ch)
 
 	r := csv.NewReader(f)
-	var schema []string = nil
+	r.Comma = CSVDialect.Delimiter
+	r.Comment = CSVDialect.Comment
+	r.LazyQuotes = CSVDialect.LazyQuotes
+	var header []string = nil
 
 	for {
 		record, err := r.Read()
@@ -28,23 +129,47 @@ func ParseCSV(path string, ch chan<- Node, l *log.Logger) {
 		}
 
 		if err != nil {
-			log.Fatalln(err)
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				l.Printf("ERROR %v: line %d: %v, row skipped\n", path, parseError.StartLine, parseError.Err)
+				continue
+			}
+			l.Printf("ERROR %v: %v\n", path, err)
+			return
 		}
 
-		if schema == nil {
-			for _, item := range record {
-				schema = append(schema, item)
+		line, _ := r.FieldPos(0)
+
+		if header == nil {
+			if !CSVDialect.NoHeader {
+				for _, item := range record {
+					header = append(header, item)
+				}
+				continue
+			}
+
+			for index := range record {
+				header = append(header, fmt.Sprintf("col%d", index+1))
 			}
-			continue
 		}
 
 		properties := make(map[string](interface{}), len(record))
 		n := NewNode(properties)
 
 		for index, item := range record {
-			n.Properties[schema[index]] = item
+			v, err := CSVDialect.Schema[header[index]].value(item)
+			if err != nil {
+				l.Printf("ERROR %v: line %d: Column %v: %v, row skipped\n", path, line, header[index], err)
+				n.Properties = nil
+				break
+			}
+			if v != nil {
+				n.Properties[header[index]] = v
+			}
 		}
 
-		ch <- n
+		if n.Properties != nil {
+			ch <- n
+		}
 	}
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setDynamic Replace the dynamic inventory settings for a test, with the user
+// cache directory in a temporary directory
+func setDynamic(t *testing.T, d DynamicOptions) {
+	saved := Dynamic
+	Dynamic = d
+	t.Cleanup(func() { Dynamic = saved })
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("LocalAppData", dir)
+}
+
+// TestFetchInventoryHeaders Check headers are sent with environment variables
+// expanded, and the body and content type returned
+func TestFetchInventoryHeaders(t *testing.T) {
+	setDynamic(t, DynamicOptions{Headers: []string{"Authorization: Bearer $CMDB_TOKEN", "X-Team:ops"}, Timeout: 5 * time.Second})
+	t.Setenv("CMDB_TOKEN", "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Team") != "ops" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `[{"node":"a"}]`)
+	}))
+	defer server.Close()
+
+	body, contentType, err := FetchInventory(server.URL+"/hosts", log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `[{"node":"a"}]` || contentType != "application/json" {
+		t.Errorf("FetchInventory = %q, %q", body, contentType)
+	}
+}
+
+// TestFetchInventoryStatus Check responses other than 200 are errors
+func TestFetchInventoryStatus(t *testing.T) {
+	setDynamic(t, DynamicOptions{Timeout: 5 * time.Second})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, _, err := FetchInventory(server.URL, log.New(io.Discard, "", 0))
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("FetchInventory error = %v, want HTTP 503", err)
+	}
+}
+
+// TestFetchInventoryCache Check fresh cached copies are used without a
+// request, and stale ones when a fetch fails
+func TestFetchInventoryCache(t *testing.T) {
+	setDynamic(t, DynamicOptions{Cache: time.Hour, Timeout: 5 * time.Second})
+
+	var requests int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		io.WriteString(w, "node\na\n")
+	}))
+	defer server.Close()
+
+	var logged bytes.Buffer
+	l := log.New(&logged, "", 0)
+
+	for i := 0; i < 2; i++ {
+		body, contentType, err := FetchInventory(server.URL, l)
+		if err != nil || string(body) != "node\na\n" || contentType != "text/csv" {
+			t.Fatalf("FetchInventory = %q, %q, %v", body, contentType, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("%d requests, want 1 with a fresh cached copy", requests)
+	}
+
+	Dynamic.Cache = time.Nanosecond // Stale
+	failing.Store(true)
+	body, _, err := FetchInventory(server.URL, l)
+	if err != nil || string(body) != "node\na\n" {
+		t.Fatalf("FetchInventory = %q, %v, want the cached copy", body, err)
+	}
+	if requests != 2 || !strings.Contains(logged.String(), "using cached copy") {
+		t.Errorf("%d requests, logged %q", requests, logged.String())
+	}
+
+	if stat, err := os.Stat(inventoryCachePath(server.URL)); err != nil || runtime.GOOS != "windows" && stat.Mode().Perm() != 0600 {
+		t.Errorf("Cache file %v, %v, want mode 0600", stat, err)
+	}
+}
+
+// TestRunInventory Check inventory scripts are run with --list
+func TestRunInventory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Shell script fixture")
+	}
+	setDynamic(t, DynamicOptions{Timeout: 5 * time.Second})
+
+	data, err := RunInventory(filepath.Join("testdata", "inventory.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAnsibleInventory(data) {
+		t.Errorf("RunInventory = %q, want Ansible inventory JSON", data)
+	}
+}
+
+// TestRunInventoryErrors Check failures report stderr and timeouts
+func TestRunInventoryErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Shell script fixture")
+	}
+	setDynamic(t, DynamicOptions{Timeout: 200 * time.Millisecond})
+
+	dir := t.TempDir()
+	failing := filepath.Join(dir, "failing")
+	slow := filepath.Join(dir, "slow")
+	os.WriteFile(failing, []byte("#!/bin/sh\necho broken >&2\nexit 3\n"), 0755)
+	os.WriteFile(slow, []byte("#!/bin/sh\nsleep 10\n"), 0755)
+
+	if _, err := RunInventory(failing); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("RunInventory(failing) error = %v, want stderr", err)
+	}
+
+	start := time.Now()
+	if _, err := RunInventory(slow); err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Errorf("RunInventory(slow) error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunInventory(slow) took %v", elapsed)
+	}
+}
+
+// TestScheduleNodesScripts Check scripts run when named, but not when found
+// in a directory
+func TestScheduleNodesScripts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Shell script fixture")
+	}
+	setDynamic(t, DynamicOptions{Timeout: 5 * time.Second})
+
+	var logged bytes.Buffer
+	l := log.New(&logged, "", 0)
+
+	var nodes []Node
+	ScheduleNodes(filepath.Join("testdata", "inventory.sh"), "auto", nil, func(n Node) { nodes = append(nodes, n) }, l)
+	if len(nodes) != 2 {
+		t.Errorf("Named script gave %d nodes, want 2: %v", len(nodes), logged.String())
+	}
+
+	nodes = nil
+	ScheduleNodes("testdata", "auto", nil, func(n Node) { nodes = append(nodes, n) }, l)
+	if len(nodes) != 0 || !strings.Contains(logged.String(), "only run when given as -inventory") {
+		t.Errorf("Script in a directory gave %d nodes, logged %q", len(nodes), logged.String())
+	}
+}
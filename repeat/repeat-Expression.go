@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Expression Boolean filter expression evaluated against a node
+type Expression interface {
+	Match(n Node) bool
+}
+
+// AndExpression Matches when both sub-expressions match
+type AndExpression struct {
+	Left  Expression
+	Right Expression
+}
+
+// Match Evaluate expression against node
+func (e AndExpression) Match(n Node) bool {
+	return e.Left.Match(n) && e.Right.Match(n)
+}
+
+// OrExpression Matches when either sub-expression matches
+type OrExpression struct {
+	Left  Expression
+	Right Expression
+}
+
+// Match Evaluate expression against node
+func (e OrExpression) Match(n Node) bool {
+	return e.Left.Match(n) || e.Right.Match(n)
+}
+
+// NotExpression Matches when the sub-expression does not match
+type NotExpression struct {
+	Expression Expression
+}
+
+// Match Evaluate expression against node
+func (e NotExpression) Match(n Node) bool {
+	return !e.Expression.Match(n)
+}
+
+// token Lexical item of a filter expression
+type token struct {
+	Text   string
+	Quoted bool // Token contained quoted text and is never a keyword
+}
+
+// keyword Return the lower-cased keyword a token represents, or an empty string
+func (t token) keyword() string {
+	if t.Quoted {
+		return ""
+	}
+
+	switch strings.ToLower(t.Text) {
+	case "(", ")", "and", "or", "not":
+		return strings.ToLower(t.Text)
+	case "&&":
+		return "and"
+	case "||":
+		return "or"
+	}
+
+	return ""
+}
+
+// tokenize Split filter arguments into expression tokens. Parentheses opening
+// a token or closing an enclosing group are tokens of their own, balanced
+// parentheses within a token are kept, and quotes group text containing
+// spaces, parentheses or keywords into a single token.
+func tokenize(args []string) ([]token, error) {
+	var tokens []token
+
+	for _, arg := range args {
+		var b strings.Builder
+		var quote rune
+		inToken := false
+		quoted := false
+		depth := 0
+
+		flush := func() {
+			if inToken {
+				tokens = append(tokens, token{Text: b.String(), Quoted: quoted})
+			}
+			b.Reset()
+			inToken = false
+			quoted = false
+			depth = 0
+		}
+
+		runes := []rune(arg)
+		for i := 0; i < len(runes); i++ {
+			r := runes[i]
+
+			if quote != 0 { // Inside quoted text
+				switch {
+				case r == quote:
+					quote = 0
+				case r == '\\' && quote == '"' && i+1 < len(runes):
+					i++
+					b.WriteRune(runes[i])
+				default:
+					b.WriteRune(r)
+				}
+				continue
+			}
+
+			switch r {
+			case '"', '\'':
+				quote = r
+				inToken = true
+				quoted = true
+			case ' ', '\t', '\n', '\r':
+				flush()
+			case '(', ')':
+				if inToken && r == '(' { // Balanced parentheses within a token are literal
+					depth++
+					b.WriteRune(r)
+					continue
+				}
+				if inToken && depth > 0 {
+					depth--
+					b.WriteRune(r)
+					continue
+				}
+				flush()
+				tokens = append(tokens, token{Text: string(r)})
+			default:
+				b.WriteRune(r)
+				inToken = true
+			}
+		}
+
+		if quote != 0 {
+			return nil, fmt.Errorf("Unterminated quote in %q", arg)
+		}
+		flush()
+	}
+
+	return joinRelativeTimes(tokens), nil
+}
+
+// joinRelativeTimes Attach unquoted ago and from now tokens to the filter
+// before them, so 'last_seen<=7d ago' needs no inner quotes
+func joinRelativeTimes(tokens []token) []token {
+	var joined []token
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		suffix := ""
+		switch {
+		case t.Quoted:
+		case strings.EqualFold(t.Text, "ago"):
+			suffix = " " + t.Text
+		case strings.EqualFold(t.Text, "from") && i+1 < len(tokens) && !tokens[i+1].Quoted && strings.EqualFold(tokens[i+1].Text, "now"):
+			suffix = " " + t.Text + " " + tokens[i+1].Text
+		}
+
+		if last := len(joined) - 1; suffix != "" && last >= 0 && joined[last].keyword() == "" {
+			joined[last].Text += suffix
+			if strings.Contains(suffix, "from") {
+				i++
+			}
+			continue
+		}
+		joined = append(joined, t)
+	}
+
+	return joined
+}
+
+// expressionParser Recursive descent parser over expression tokens
+type expressionParser struct {
+	tokens   []token
+	position int
+}
+
+// peek Return the next token without consuming it
+func (p *expressionParser) peek() (token, bool) {
+	if p.position >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.position], true
+}
+
+// parseOr or := and { "or" and }
+func (p *expressionParser) parseOr() (Expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t, ok := p.peek()
+		if !ok || t.keyword() != "or" {
+			return left, nil
+		}
+		p.position++
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = OrExpression{Left: left, Right: right}
+	}
+}
+
+// parseAnd and := unary { ["and"] unary }, adjacent terms are implicitly ANDed
+func (p *expressionParser) parseAnd() (Expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t, ok := p.peek()
+		if !ok || t.keyword() == "or" || t.keyword() == ")" {
+			return left, nil
+		}
+		if t.keyword() == "and" {
+			p.position++
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = AndExpression{Left: left, Right: right}
+	}
+}
+
+// parseUnary unary := "not" unary | primary
+func (p *expressionParser) parseUnary() (Expression, error) {
+	t, ok := p.peek()
+	if ok && t.keyword() == "not" {
+		p.position++
+
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return NotExpression{Expression: e}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+// parsePrimary primary := "(" or ")" | filter
+func (p *expressionParser) parsePrimary() (Expression, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, errors.New("Unexpected end of filter expression")
+	}
+
+	switch t.keyword() {
+	case "(":
+		p.position++
+
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		t, ok = p.peek()
+		if !ok || t.keyword() != ")" {
+			return nil, errors.New("Missing closing parenthesis in filter expression")
+		}
+		p.position++
+
+		return e, nil
+	case "":
+		p.position++
+
+		if f, ok, err := p.parseWordFilter(t); ok {
+			return f, err
+		}
+
+		f, err := NewFilter(t.Text)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %q", err, t.Text)
+		}
+		return f, nil
+	}
+
+	return nil, fmt.Errorf("Unexpected %q in filter expression", t.Text)
+}
+
+// parseWordFilter filter := key ("exists" | "missing" | "in" value). Reports
+// false when the tokens following key are not a word comparator.
+func (p *expressionParser) parseWordFilter(key token) (Expression, bool, error) {
+	t, ok := p.peek()
+	if !ok || t.Quoted {
+		return nil, false, nil
+	}
+
+	comparator := strings.ToLower(t.Text)
+	values, ok := WordComparators[comparator]
+	if !ok {
+		return nil, false, nil
+	}
+	p.position++
+
+	value := ""
+	if values > 0 {
+		t, ok = p.peek()
+		if !ok || t.keyword() != "" {
+			return nil, true, fmt.Errorf("Missing value for %q in filter expression", comparator)
+		}
+		p.position++
+		value = t.Text
+	}
+
+	f, err := BuildFilter(key.Text, comparator, value)
+	if err != nil {
+		return nil, true, fmt.Errorf("%v: %q", err, key.Text+" "+comparator+" "+value)
+	}
+	return f, true, nil
+}
+
+// ParseExpression Parse filter arguments into an expression tree. An empty
+// argument list yields a nil Expression, which matches every node.
+func ParseExpression(args []string) (Expression, error) {
+	tokens, err := tokenize(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := expressionParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.position < len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected %q in filter expression", p.tokens[p.position].Text)
+	}
+
+	return e, nil
+}
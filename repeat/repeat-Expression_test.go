@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestTokenizeRelativeTimes Check ago and from now stay with their filter
+func TestTokenizeRelativeTimes(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"last_seen<=7d ago"}, []string{"last_seen<=7d ago"}},
+		{[]string{"last_seen<=7d", "AGO"}, []string{"last_seen<=7d AGO"}},
+		{[]string{"expires<=2w from now and type==server"}, []string{"expires<=2w from now", "and", "type==server"}},
+		{[]string{`last_seen<="7d ago"`}, []string{"last_seen<=7d ago"}},
+		{[]string{"(a==1)", "ago"}, []string{"(", "a==1", ")", "ago"}},
+		{[]string{"a==1", `"ago"`}, []string{"a==1", "ago"}},
+	}
+
+	for _, test := range tests {
+		tokens, err := tokenize(test.args)
+		if err != nil {
+			t.Fatalf("tokenize(%q): %v", test.args, err)
+		}
+		var got []string
+		for _, token := range tokens {
+			got = append(got, token.Text)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Filter Filter to apply to a list of nodes
@@ -11,39 +15,237 @@ type Filter struct {
 	Key        string
 	Value      interface{}
 	Type       string
-	Comparator string // ==, >=, <=, !=, ~=
+	Comparator string // ==, >=, <=, !=, ~=, =~, !~, *=, @, in, exists, missing
 
 }
 
+// Comparators Known symbolic filter comparators
+var Comparators = []string{"==", "!=", ">=", "<=", "~=", "=~", "!~", "*=", "@"}
+
+// WordComparators Known word filter comparators, written as separate tokens
+// and mapped to the number of value tokens they take
+var WordComparators = map[string]int{"in": 1, "exists": 0, "missing": 0}
+
 // NewFilter Create a Filter object from a string filter definition
 func NewFilter(filter string) (Filter, error) {
-	index := strings.LastIndex(filter, "=")
-	if index < 0 {
+	/* Split on the first comparator so values may contain comparator
+	characters themselves */
+
+	index := strings.IndexAny(filter, "=!~<>*@")
+	if index < 1 {
 		return Filter{}, errors.New("Invalid filter")
 	}
 
-	/* Parse for type hints */
+	comparator := ""
+	for _, c := range Comparators {
+		if strings.HasPrefix(filter[index:], c) {
+			comparator = c
+			break
+		}
+	}
+	if comparator == "" {
+		return Filter{}, errors.New("Invalid filter comparator")
+	}
 
-	var vAsString string = filter[index+1:]
-	var vAsI interface{} = vAsString
-	vtype := "s"
+	return BuildFilter(filter[:index], comparator, filter[index+len(comparator):])
+}
 
-	vAsFloat, err := strconv.ParseFloat(vAsString, 64) // If parsed, set values
-	if err == nil {
-		vtype = "f"
-		vAsI = vAsFloat
+// BuildFilter Create a Filter object from its key, comparator and value,
+// pre-compiling the value for the comparator
+func BuildFilter(key string, comparator string, value string) (Filter, error) {
+	f := Filter{
+		Key:        key,
+		Value:      value,
+		Type:       "s",
+		Comparator: comparator,
 	}
 
-	vAsInt, err := strconv.ParseInt(vAsString, 10, 64) // If parsed, set values
-	if err == nil {
-		vtype = "i"
-		vAsI = vAsInt
+	switch comparator {
+	case "=~", "!~": // Regular expression
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return Filter{}, err
+		}
+		f.Type = "r"
+		f.Value = re
+	case "*=": // Shell glob, case-insensitive like string equality
+		if _, err := path.Match(value, ""); err != nil {
+			return Filter{}, err
+		}
+		f.Type = "g"
+		f.Value = strings.ToLower(value)
+	case "in": // Comma-separated set
+		var set []string
+		for _, item := range strings.Split(value, ",") {
+			set = append(set, strings.TrimSpace(item))
+		}
+		f.Type = "l"
+		f.Value = set
+	case "@": // Address ranges, comma-separated
+		var ranges []AddressRange
+		for _, item := range strings.Split(value, ",") {
+			r, err := ParseAddressRange(strings.TrimSpace(item))
+			if err != nil {
+				return Filter{}, err
+			}
+			ranges = append(ranges, r)
+		}
+		f.Type = "n"
+		f.Value = ranges
+	case "exists", "missing": // Presence test, no value
+		f.Type = "e"
+		f.Value = nil
+	case "~=": // Substring matches are always string tests
+	default:
+		/* Parse for type hints */
+
+		vAsFloat, err := strconv.ParseFloat(value, 64) // If parsed, set values
+		if err == nil {
+			f.Type = "f"
+			f.Value = vAsFloat
+		}
+
+		vAsInt, err := strconv.ParseInt(value, 10, 64) // If parsed, set values
+		if err == nil {
+			f.Type = "i"
+			f.Value = vAsInt
+		}
+
+		if f.Type == "s" {
+			vAsTime, err := ParseFilterTime(value) // If parsed, set values
+			if err == nil {
+				f.Type = "t"
+				f.Value = vAsTime
+			}
+		}
+	}
+
+	return f, nil
+}
+
+// Match Indicate if the given node satisfies the filter
+func (f Filter) Match(n Node) bool {
+	v, err := n.GetProperty(&f.Key)
+
+	switch f.Comparator {
+	case "exists":
+		return err == nil
+	case "missing":
+		return err != nil
+	}
+
+	negated := f.Comparator == "!=" || f.Comparator == "!~"
+	if err != nil { // Handle missing properties
+		return negated
+	}
+
+	if f.Type == "n" { // Test any address held by the property against the ranges
+		for _, addr := range ParseAddresses(v) {
+			for _, r := range f.Value.([]AddressRange) {
+				if r.Contains(addr) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	/* Properties holding lists match when any item matches, or for negated
+	comparators when no item fails to match */
+
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if f.compare(item) != negated {
+				return !negated
+			}
+		}
+		return negated
+	}
+
+	return f.compare(v)
+}
+
+// compare Test a single property value against the filter
+func (f Filter) compare(v interface{}) bool {
+	switch f.Type { // Route based on type
+	case "r": // Test against regular expression
+		matched := f.Value.(*regexp.Regexp).MatchString(fmt.Sprintf("%v", v))
+		return matched == (f.Comparator == "=~")
+	case "g": // Test against shell glob
+		matched, _ := path.Match(f.Value.(string), strings.ToLower(fmt.Sprintf("%v", v)))
+		return matched
+	case "l": // Test for set membership
+		vs := fmt.Sprintf("%v", v)
+		for _, item := range f.Value.([]string) {
+			if strings.EqualFold(item, vs) {
+				return true
+			}
+		}
+		return false
+	case "s": // Test as string
+		vs := fmt.Sprintf("%v", v)
+
+		switch f.Comparator { // Route based on comparator
+		case "==":
+			return strings.EqualFold(f.Value.(string), vs)
+		case "!=":
+			return !strings.EqualFold(f.Value.(string), vs)
+		case ">=":
+			return len(vs) >= len(f.Value.(string))
+		case "<=":
+			return len(vs) <= len(f.Value.(string))
+		case "~=":
+			return strings.Contains(strings.ToLower(vs), strings.ToLower(f.Value.(string)))
+		}
+	case "f": // Test as float
+		vparsed, err := strconv.ParseFloat(fmt.Sprintf("%v", v), 64)
+		if err != nil {
+			return false
+		}
+
+		switch f.Comparator {
+		case "==":
+			return vparsed == f.Value.(float64)
+		case "!=":
+			return vparsed != f.Value.(float64)
+		case ">=":
+			return vparsed >= f.Value.(float64)
+		case "<=":
+			return vparsed <= f.Value.(float64)
+		}
+	case "t": // Test as time
+		vparsed, err := ParsePropertyTime(f.Key, v)
+		if err != nil {
+			return false
+		}
+
+		switch f.Comparator {
+		case "==":
+			return vparsed.Equal(f.Value.(time.Time))
+		case "!=":
+			return !vparsed.Equal(f.Value.(time.Time))
+		case ">=":
+			return !vparsed.Before(f.Value.(time.Time))
+		case "<=":
+			return !vparsed.After(f.Value.(time.Time))
+		}
+	case "i": // Test as integer
+		vparsed, err := strconv.ParseInt(fmt.Sprintf("%v", v), 10, 64)
+		if err != nil {
+			return false
+		}
+
+		switch f.Comparator {
+		case "==":
+			return vparsed == f.Value.(int64)
+		case "!=":
+			return vparsed != f.Value.(int64)
+		case ">=":
+			return vparsed >= f.Value.(int64)
+		case "<=":
+			return vparsed <= f.Value.(int64)
+		}
 	}
 
-	return Filter{
-		Key:        filter[:index-1],
-		Value:      vAsI,
-		Type:       vtype,
-		Comparator: filter[index-1 : index+1],
-	}, nil
+	return false
 }
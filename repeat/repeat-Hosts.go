@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/netip"
+	"strings"
+)
+
+// ParseHosts Parse /etc/hosts style inventory read from f writing a node for
+// each line to chan, with the address, first host name as the node name, and
+// any further names as aliases
+func ParseHosts(path string, f io.Reader, ch chan<- Node, l *log.Logger) {
+	defer close(ch)
+
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if index := strings.IndexByte(line, '#'); index >= 0 {
+			line = line[:index]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			l.Printf("ERROR %v: line %d: Expected an address and host name\n", path, number)
+			continue
+		}
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			l.Printf("ERROR %v: line %d: %v\n", path, number, err)
+			continue
+		}
+
+		aliases := make([]interface{}, 0, len(fields)-2)
+		for _, alias := range fields[2:] {
+			aliases = append(aliases, alias)
+		}
+
+		ch <- NewNode(map[string]interface{}{
+			"address": fields[0],
+			NameKey:   fields[1],
+			"aliases": aliases,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		l.Printf("ERROR %v: %v\n", path, err)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseINI Parse Ansible INI inventory read from f writing found nodes to
+// chan. Each host's groups, including parents through :children sections, are
+// stored in the groups property, and group and host variables become
+// properties.
+func ParseINI(path string, f io.Reader, ch chan<- Node, l *log.Logger) {
+	defer close(ch)
+
+	inventory, err := parseINI(f)
+	if err != nil {
+		l.Printf("ERROR %v: %v\n", path, err)
+		return
+	}
+
+	for _, host := range inventory.order {
+		ch <- NewNode(inventory.properties(host))
+	}
+}
+
+// iniGroup An Ansible inventory group
+type iniGroup struct {
+	vars     map[string]interface{}
+	children []string
+}
+
+// iniInventory A parsed Ansible INI inventory
+type iniInventory struct {
+	groups map[string]*iniGroup
+	hosts  map[string]map[string]interface{} // Host variables
+	member map[string][]string               // Groups each host is listed in
+	order  []string                          // Hosts in order of first appearance
+}
+
+// newINIInventory Return an empty inventory
+func newINIInventory() *iniInventory {
+	return &iniInventory{
+		groups: make(map[string]*iniGroup),
+		hosts:  make(map[string]map[string]interface{}),
+		member: make(map[string][]string),
+	}
+}
+
+// parseINI Parse an Ansible INI inventory
+func parseINI(r io.Reader) (*iniInventory, error) {
+	inventory := newINIInventory()
+
+	group, kind := "ungrouped", "hosts"
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' { // Section header
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: Invalid section %q", number, line)
+			}
+
+			group, kind = line[1:len(line)-1], "hosts"
+			if index := strings.Index(group, ":"); index >= 0 {
+				group, kind = group[:index], group[index+1:]
+			}
+			if kind != "hosts" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("line %d: Unknown section type %q", number, kind)
+			}
+			inventory.group(group)
+			continue
+		}
+
+		var err error
+		switch kind {
+		case "hosts":
+			err = inventory.addHosts(group, line)
+		case "vars":
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				err = fmt.Errorf("Expected key=value, found %q", line)
+				break
+			}
+			inventory.group(group).vars[strings.TrimSpace(key)] = iniValue(strings.TrimSpace(value))
+		case "children":
+			inventory.group(line)
+			inventory.group(group).children = append(inventory.group(group).children, line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number, err)
+		}
+	}
+
+	return inventory, scanner.Err()
+}
+
+// group Return the named group, creating it when missing
+func (inventory *iniInventory) group(name string) *iniGroup {
+	g, ok := inventory.groups[name]
+	if !ok {
+		g = &iniGroup{vars: make(map[string]interface{})}
+		inventory.groups[name] = g
+	}
+	return g
+}
+
+// addHosts Add the hosts matching a host line's pattern to group, with the
+// line's key=value variables
+func (inventory *iniInventory) addHosts(group string, line string) error {
+	fields, err := splitINIFields(line)
+	if err != nil {
+		return err
+	}
+
+	hosts, err := expandHostPattern(fields[0])
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		vars := inventory.host(host, group)
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return fmt.Errorf("Expected key=value, found %q", field)
+			}
+			vars[key] = iniValue(value)
+		}
+	}
+
+	return nil
+}
+
+// host Return the variables of host, adding it when missing, and record it
+// as a member of group
+func (inventory *iniInventory) host(host string, group string) map[string]interface{} {
+	vars, ok := inventory.hosts[host]
+	if !ok {
+		vars = make(map[string]interface{})
+		inventory.hosts[host] = vars
+		inventory.order = append(inventory.order, host)
+	}
+	if group != "all" && group != "" {
+		inventory.member[host] = append(inventory.member[host], group)
+	}
+	return vars
+}
+
+// ancestors Add group and the groups it is a child of to seen
+func (inventory *iniInventory) ancestors(group string, seen map[string]bool) {
+	if seen[group] {
+		return
+	}
+	seen[group] = true
+
+	for name, g := range inventory.groups {
+		for _, child := range g.children {
+			if child == group {
+				inventory.ancestors(name, seen)
+			}
+		}
+	}
+}
+
+// depth Return how deeply group is nested below all, at least 1
+func (inventory *iniInventory) depth(group string, visiting map[string]bool) int {
+	if visiting[group] {
+		return 0
+	}
+	visiting[group] = true
+	defer delete(visiting, group)
+
+	depth := 1
+	for name, g := range inventory.groups {
+		for _, child := range g.children {
+			if child == group && name != "all" {
+				if d := inventory.depth(name, visiting) + 1; d > depth {
+					depth = d
+				}
+			}
+		}
+	}
+	return depth
+}
+
+// properties Return the properties of host: all:vars, then the variables of
+// its groups from the least to the most deeply nested, then host variables
+func (inventory *iniInventory) properties(host string) map[string]interface{} {
+	seen := make(map[string]bool)
+	for _, group := range inventory.member[host] {
+		inventory.ancestors(group, seen)
+	}
+	delete(seen, "all")
+	if len(seen) == 0 {
+		seen["ungrouped"] = true
+	}
+
+	var groups []string
+	depths := make(map[string]int)
+	for group := range seen {
+		groups = append(groups, group)
+		depths[group] = inventory.depth(group, make(map[string]bool))
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if depths[groups[i]] != depths[groups[j]] {
+			return depths[groups[i]] < depths[groups[j]]
+		}
+		return groups[i] < groups[j]
+	})
+
+	properties := make(map[string]interface{})
+	for _, group := range append([]string{"all"}, groups...) {
+		if g, ok := inventory.groups[group]; ok {
+			for k, v := range g.vars {
+				properties[k] = v
+			}
+		}
+	}
+	for k, v := range inventory.hosts[host] {
+		properties[k] = v
+	}
+
+	if _, set := properties[NameKey]; !set {
+		properties[NameKey] = host
+	}
+
+	sort.Strings(groups)
+	list := make([]interface{}, len(groups))
+	for index, group := range groups {
+		list[index] = group
+	}
+	properties["groups"] = list
+
+	return properties
+}
+
+// splitINIFields Split a host line into whitespace separated fields, keeping
+// quoted spans together and stopping at a # comment
+func splitINIFields(line string) ([]string, error) {
+	var fields []string
+	var b strings.Builder
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(line) {
+				b.WriteByte(c)
+				i++
+				c = line[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ' ' || c == '\t':
+			if b.Len() > 0 {
+				fields = append(fields, b.String())
+				b.Reset()
+			}
+			continue
+		case c == '#' && b.Len() == 0:
+			i = len(line)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	if quote != 0 {
+		return nil, errors.New("Unterminated quoted value")
+	}
+	if b.Len() > 0 {
+		fields = append(fields, b.String())
+	}
+	return fields, nil
+}
+
+// iniValue Return the value of a variable, unquoting quoted strings and
+// reading numbers and booleans
+func iniValue(s string) interface{} {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		if s[0] == '"' {
+			if v, err := strconv.Unquote(s); err == nil {
+				return v
+			}
+		}
+		return s[1 : len(s)-1]
+	}
+
+	switch strings.ToLower(s) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if plainNumber.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+
+	return s
+}
+
+// maxHostRange Most hosts a host pattern may expand to
+const maxHostRange = 1 << 16
+
+// expandHostPattern Expand Ansible host ranges such as web[01:20:2] or
+// db-[a:c] into host names, keeping the zero padding of numeric ranges
+func expandHostPattern(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	end := strings.Index(pattern, "]")
+	if start < 0 || end < start || !strings.Contains(pattern[start:end], ":") {
+		return []string{pattern}, nil
+	}
+
+	bounds := strings.Split(pattern[start+1:end], ":")
+	if len(bounds) > 3 {
+		return nil, fmt.Errorf("Invalid host range %q", pattern[start:end+1])
+	}
+
+	step := 1
+	if len(bounds) == 3 {
+		var err error
+		if step, err = strconv.Atoi(bounds[2]); err != nil || step < 1 {
+			return nil, fmt.Errorf("Invalid host range step %q", bounds[2])
+		}
+	}
+
+	var items []string
+	first, errFirst := strconv.Atoi(bounds[0])
+	last, errLast := strconv.Atoi(bounds[1])
+	switch {
+	case errFirst == nil && errLast == nil && first >= 0 && last >= 0:
+		if last >= first && (last-first)/step >= maxHostRange {
+			return nil, fmt.Errorf("Host range %q expands to more than %d hosts", pattern[start:end+1], maxHostRange)
+		}
+		for i := 0; last >= first && i <= (last-first)/step; i++ { // Counted, as first+i*step may overflow past last
+			items = append(items, fmt.Sprintf("%0*d", len(bounds[0]), first+i*step))
+		}
+	case len(bounds[0]) == 1 && len(bounds[1]) == 1:
+		for c := bounds[0][0]; c <= bounds[1][0]; c += byte(step) {
+			items = append(items, string(c))
+			if int(c)+step > 255 {
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Invalid host range %q", pattern[start:end+1])
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("Empty host range %q", pattern[start:end+1])
+	}
+
+	rest, err := expandHostPattern(pattern[end+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items)*len(rest) > maxHostRange {
+		return nil, fmt.Errorf("Host pattern %q expands to more than %d hosts", pattern, maxHostRange)
+	}
+
+	var hosts []string
+	for _, item := range items {
+		for _, suffix := range rest {
+			hosts = append(hosts, pattern[:start]+item+suffix)
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// iniTests Ansible INI inventories with each host's resolved properties, or
+// the start of their error
+var iniTests = []struct {
+	name  string
+	input string
+	want  map[string]map[string]interface{}
+	err   string
+}{
+	{"empty", "", map[string]map[string]interface{}{}, ""},
+	{"ungrouped", "a ansible_host=10.0.0.1\n",
+		map[string]map[string]interface{}{
+			"a": {"node": "a", "ansible_host": "10.0.0.1", "groups": []interface{}{"ungrouped"}},
+		}, ""},
+	{"groups and vars", "[all:vars]\nenv=prod\nport=22\n\n[web]\nw1 port=2222\n\n[web:vars]\nrole=web\n",
+		map[string]map[string]interface{}{
+			"w1": {"node": "w1", "env": "prod", "port": float64(2222), "role": "web", "groups": []interface{}{"web"}},
+		}, ""},
+	{"children", "[web]\nw1\n\n[prod:children]\nweb\n\n[prod:vars]\nenv=prod\n\n[web:vars]\nenv=web\n",
+		map[string]map[string]interface{}{
+			"w1": {"node": "w1", "env": "web", "groups": []interface{}{"prod", "web"}},
+		}, ""},
+	{"ranges", "[web]\nw[1:3:2] x='quoted 1'\n",
+		map[string]map[string]interface{}{
+			"w1": {"node": "w1", "x": "quoted 1", "groups": []interface{}{"web"}},
+			"w3": {"node": "w3", "x": "quoted 1", "groups": []interface{}{"web"}},
+		}, ""},
+	{"child cycle", "[a:children]\nb\n[b:children]\na\n[a]\nh\n", nil, ""},
+	{"bad section", "[web\nh\n", nil, "line 1:"},
+	{"unknown section type", "[web:other]\n", nil, "line 1:"},
+	{"bad vars", "[web:vars]\nnovalue\n", nil, "line 2:"},
+	{"bad range", "[web]\nw[3:1]\n", nil, "line 2:"},
+	{"negative range", "[web]\nw[-1:1]\n", nil, "line 2:"},
+	{"overflowing range", "[web]\nw[9223372036854775806:9223372036854775807]\n", nil, ""},
+	{"huge range", "[web]\nw[0:99999999]\n", nil, "line 2: Host range"},
+	{"huge product", "[web]\nw[0:999]-[0:999]\n", nil, "line 2: Host pattern"},
+}
+
+// TestParseINI Check inventories resolve to the expected host properties or
+// errors
+func TestParseINI(t *testing.T) {
+	for _, test := range iniTests {
+		t.Run(test.name, func(t *testing.T) {
+			inventory, err := parseINI(strings.NewReader(test.input))
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("parseINI(%q) error = %v, want %q", test.input, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseINI(%q): %v", test.input, err)
+			}
+
+			got := make(map[string]map[string]interface{})
+			for _, host := range inventory.order {
+				got[host] = inventory.properties(host)
+			}
+			if test.want != nil && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseINI(%q) = %#v, want %#v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+// FuzzParseINI Check no input panics or loops while resolving properties
+func FuzzParseINI(f *testing.F) {
+	for _, test := range iniTests {
+		f.Add(test.input)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		inventory, err := parseINI(strings.NewReader(s))
+		if err != nil {
+			return
+		}
+		for _, host := range inventory.order {
+			inventory.properties(host)
+		}
+	})
+}
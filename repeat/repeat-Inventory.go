@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net/netip"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Parsers Inventory parsers by -format name
+var Parsers = map[string]func(path string, f io.Reader, ch chan<- Node, l *log.Logger){
+	"csv":     ParseCSV,
+	"json":    ParseJSON,
+	"ndjson":  ParseJSON,
+	"yaml":    ParseYAML,
+	"toml":    ParseTOML,
+	"ini":     ParseINI,
+	"hosts":   ParseHosts,
+	"ansible": ParseAnsibleJSON,
+}
+
+// FormatByName Return the inventory format for a file name, or "" when the
+// name does not say. Files named hosts may also be INI inventories, which
+// HostsOrINI tells apart.
+func FormatByName(path string) string {
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".csv":
+		return "csv"
+	case ext == ".json":
+		return "json"
+	case ext == ".ndjson" || ext == ".jsonl":
+		return "ndjson"
+	case ext == ".yaml" || ext == ".yml":
+		return "yaml"
+	case ext == ".toml":
+		return "toml"
+	case ext == ".ini":
+		return "ini"
+	case ext == ".hosts" || isHostsName(path):
+		return "hosts"
+	}
+
+	return ""
+}
+
+// isHostsName Return true if the file is named hosts, as both hosts files and
+// Ansible INI inventories conventionally are
+func isHostsName(path string) bool {
+	return strings.EqualFold(filepath.Base(path), "hosts")
+}
+
+// HostsOrINI Return the format of an inventory named hosts from its start:
+// ini when it holds a [section] line or its first line does not open with an
+// address, otherwise hosts
+func HostsOrINI(head []byte) string {
+	lines := contentLines(head, false)
+	for _, line := range lines {
+		if sectionHeader.MatchString(line) {
+			return "ini"
+		}
+	}
+
+	if len(lines) > 0 {
+		if _, err := netip.ParseAddr(strings.Fields(lines[0])[0]); err != nil {
+			return "ini"
+		}
+	}
+
+	return "hosts"
+}
+
+// SniffFormat Return the inventory format the start of an inventory looks
+// like: INI or TOML when its first line is a [section] header, JSON when it
+// opens with [ or {, YAML when its first line is ---, a - item or a key:,
+// hosts when its first line is an address and name, otherwise CSV
+func SniffFormat(head []byte) string {
+	lines := contentLines(head, false)
+	line := ""
+	if len(lines) > 0 {
+		line = lines[0]
+	}
+
+	switch {
+	case sectionHeader.MatchString(line):
+		if format := sniffSection(lines); format != "" {
+			return format
+		}
+		return "ini"
+	case strings.HasPrefix(line, "[") || strings.HasPrefix(line, "{"):
+		return "json"
+	case line == "---" || isYAMLSequenceItem(line):
+		return "yaml"
+	case strings.Contains(line, ","):
+		return "csv"
+	case strings.HasSuffix(line, ":"):
+		return "yaml"
+	}
+
+	if _, _, ok := splitYAMLKey(line); ok {
+		return "yaml"
+	}
+	if fields := strings.Fields(line); len(fields) >= 2 {
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			return "hosts"
+		}
+	}
+
+	return "csv"
+}
+
+// sectionHeader INI [group] or TOML [table] and [[array]] header line, which
+// unlike a JSON array holds a bare name
+var sectionHeader = regexp.MustCompile(`^\[\[?[A-Za-z0-9_][^\[\]\s"',{}=]*\]\]?$`)
+
+// sniffSection Return the format of an inventory opening with the section
+// header lines[0]: TOML for [[array]] headers or when the first line after
+// the headers is a key = value pair, INI for [group:children] and [group:vars]
+// headers or any other line, and "" when lines hold no further line yet
+func sniffSection(lines []string) string {
+	if strings.HasPrefix(lines[0], "[[") {
+		return "toml"
+	}
+
+	for _, line := range lines {
+		switch {
+		case strings.HasSuffix(line, ":children]") || strings.HasSuffix(line, ":vars]"):
+			return "ini"
+		case sectionHeader.MatchString(line) || line[0] == ';':
+			continue
+		}
+
+		if key, _, ok := strings.Cut(line, "="); ok && !strings.ContainsAny(strings.TrimSpace(key), " \t") {
+			return "toml"
+		}
+		return "ini" // Host lines, with any variables after the host name
+	}
+
+	return ""
+}
+
+// contentLines Return the trimmed lines of head which are not blank or a
+// comment, only those ended by a newline when complete
+func contentLines(head []byte, complete bool) []string {
+	text := string(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+	if complete {
+		end := strings.LastIndexByte(text, '\n')
+		if end < 0 {
+			return nil
+		}
+		text = text[:end]
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" && line[0] != '#' {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// sniffSize Most bytes of a stream read ahead to detect its format
+const sniffSize = 4096
+
+// PeekHead Return the start of r for SniffFormat without consuming it,
+// waiting only until the first line which is not blank or a comment, or after
+// a section header the line telling INI from TOML, is complete, input ends or
+// sniffSize bytes are buffered, so a slow producer's first nodes are not held
+// back
+func PeekHead(r *bufio.Reader) []byte {
+	n := 1
+	for {
+		head, err := r.Peek(n)
+		if err != nil || n >= sniffSize || hasContentLine(head) {
+			return head
+		}
+
+		if n = r.Buffered(); n <= len(head) { // Wait for at least one more byte
+			n = len(head) + 1
+		}
+		if n > sniffSize {
+			n = sniffSize
+		}
+	}
+}
+
+// hasContentLine Return true if head holds a complete line which is not blank
+// or a comment, past any section headers until the format is known
+func hasContentLine(head []byte) bool {
+	lines := contentLines(head, true)
+	if len(lines) == 0 {
+		return false
+	}
+	if !sectionHeader.MatchString(lines[0]) {
+		return true
+	}
+
+	return sniffSection(lines) != ""
+}
+
+// ErrorCounter Writer for an inventory logger without flags, passing lines
+// on to a logger and counting those reporting errors
+type ErrorCounter struct {
+	l     *log.Logger
+	count atomic.Int64
+}
+
+// NewErrorCounter Creates and returns an ErrorCounter logging to l
+func NewErrorCounter(l *log.Logger) *ErrorCounter {
+	return &ErrorCounter{l: l}
+}
+
+// Write Log a line, counting it when it starts with ERROR
+func (c *ErrorCounter) Write(p []byte) (int, error) {
+	if bytes.HasPrefix(p, []byte("ERROR ")) {
+		c.count.Add(1)
+	}
+	c.l.Print(string(p))
+
+	return len(p), nil
+}
+
+// Count Return the number of errors logged
+func (c *ErrorCounter) Count() int {
+	return int(c.count.Load())
+}
+
+// NameKey Property holding the node name for inventories keyed by name
+var NameKey = "node"
+
+// plainNumber Unquoted values read as numbers. Leading zeros keep values such
+// as 007 strings.
+var plainNumber = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)(\.[0-9]*)?([eE][-+]?[0-9]+)?$|^[-+]?\.[0-9]+([eE][-+]?[0-9]+)?$`)
+
+// sendDocument Write the nodes of a parsed inventory document to chan. The
+// document is a list of property maps, or a map of property maps keyed by node
+// name whose keys, visited in the given order, are stored under NameKey.
+func sendDocument(path string, doc interface{}, order []string, ch chan<- Node, l *log.Logger) {
+	switch doc := doc.(type) {
+	case []interface{}:
+		for index, item := range doc {
+			properties, ok := item.(map[string]interface{})
+			if !ok {
+				l.Printf("ERROR %v: Item %d is not a map of properties\n", path, index)
+				continue
+			}
+			ch <- NewNode(properties)
+		}
+	case map[string]interface{}:
+		for _, name := range order {
+			properties, ok := doc[name].(map[string]interface{})
+			if doc[name] == nil {
+				properties, ok = make(map[string]interface{}), true
+			}
+			if !ok {
+				l.Printf("ERROR %v: Node %q is not a map of properties\n", path, name)
+				continue
+			}
+
+			if _, set := properties[NameKey]; !set {
+				properties[NameKey] = name
+			}
+			ch <- NewNode(properties)
+		}
+	case nil: // Empty document
+	default:
+		l.Printf("ERROR %v: Expected a list or map of nodes\n", path)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSniffFormat Check inventory starts are detected as the right format
+func TestSniffFormat(t *testing.T) {
+	tests := []struct {
+		head string
+		want string
+	}{
+		{`[{"node":"a"}]`, "json"},
+		{"{\"node\":\"a\"}\n{\"node\":\"b\"}\n", "json"},
+		{"# comment\n\n---\n", "yaml"},
+		{"- node: a\n", "yaml"},
+		{"web-01:\n  address: 10.0.0.1\n", "yaml"},
+		{"node,address\n", "csv"},
+		{"\xef\xbb\xbfnode,address\n", "csv"},
+		{"10.0.0.1 web-01 web\n", "hosts"},
+		{"node\n", "csv"},
+		{"[web]\nweb-01\nweb-02\n", "ini"},
+		{"# hosts\n[web]\nweb-01 ansible_host=10.0.0.1\n", "ini"},
+		{"[web:children]\nfrontend\n", "ini"},
+		{"[all:vars]\nansible_user=admin\n", "ini"},
+		{"[web]\n[db]\n", "ini"},
+		{"[web-01]\naddress = \"10.0.0.1\"\n", "toml"},
+		{"[servers.alpha]\n\n# comment\nport=22\n", "toml"},
+		{"[[nodes]]\nnode = \"a\"\n", "toml"},
+		{"[\n  {\"node\": \"a\"}\n]\n", "json"},
+		{`["a", "b"]`, "json"},
+		{"", "csv"},
+	}
+
+	for _, test := range tests {
+		if got := SniffFormat([]byte(test.head)); got != test.want {
+			t.Errorf("SniffFormat(%q) = %v, want %v", test.head, got, test.want)
+		}
+	}
+}
+
+// TestHostsOrINI Check files named hosts are read as INI inventories unless
+// they hold address lines
+func TestHostsOrINI(t *testing.T) {
+	tests := []struct {
+		head string
+		want string
+	}{
+		{"127.0.0.1 localhost\n::1 localhost ip6-localhost\n", "hosts"},
+		{"# static hosts\n10.0.0.1 web-01\n", "hosts"},
+		{"10.0.0.1 web-01\n[web]\n", "ini"},
+		{"[web]\nweb-01\n", "ini"},
+		{"web-01 ansible_host=10.0.0.1\n", "ini"},
+		{"; comment\n10.0.0.1 web-01\n", "ini"},
+		{"", "hosts"},
+	}
+
+	for _, test := range tests {
+		if got := HostsOrINI([]byte(test.head)); got != test.want {
+			t.Errorf("HostsOrINI(%q) = %v, want %v", test.head, got, test.want)
+		}
+	}
+}
+
+// TestPeekHead Check the head is returned once its first content line is
+// complete, without waiting for more input
+func TestPeekHead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("# comment\n{\"node\":\"a\"}\n"))
+
+	done := make(chan []byte)
+	go func() { done <- PeekHead(bufio.NewReader(pr)) }()
+
+	select {
+	case head := <-done:
+		if got := SniffFormat(head); got != "json" {
+			t.Errorf("SniffFormat(%q) = %v, want json", head, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PeekHead waited for more input")
+	}
+}
+
+// TestPeekHeadSection Check a section header is read past to the line
+// telling INI from TOML
+func TestPeekHeadSection(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		pw.Write([]byte("[web-01]\n"))
+		time.Sleep(50 * time.Millisecond)
+		pw.Write([]byte("address = \"10.0.0.1\"\n"))
+	}()
+
+	done := make(chan []byte)
+	go func() { done <- PeekHead(bufio.NewReader(pr)) }()
+
+	select {
+	case head := <-done:
+		if got := SniffFormat(head); got != "toml" {
+			t.Errorf("SniffFormat(%q) = %v, want toml", head, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PeekHead waited for more input")
+	}
+}
+
+// TestPeekHeadShortInput Check input shorter than a line is returned at EOF
+func TestPeekHeadShortInput(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("node"))
+		pw.Close()
+	}()
+
+	if head := string(PeekHead(bufio.NewReader(pr))); head != "node" {
+		t.Errorf("PeekHead = %q, want %q", head, "node")
+	}
+}
+
+// TestInventoryErrorsCounted Check unreadable and unparsable inventories are
+// counted as errors
+func TestInventoryErrorsCounted(t *testing.T) {
+	dir := t.TempDir()
+	truncated := filepath.Join(dir, "truncated.json")
+	if err := os.WriteFile(truncated, []byte(`[{"node":"a"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing"), truncated} {
+		counter := NewErrorCounter(log.New(io.Discard, "", 0))
+		matched := 0
+		ScheduleNodes(path, "auto", nil, func(Node) { matched++ }, log.New(counter, "", 0))
+
+		if matched != 0 || counter.Count() != 1 {
+			t.Errorf("%v: matched %d with %d errors, want 0 with 1", filepath.Base(path), matched, counter.Count())
+		}
+	}
+}
@@ -2,33 +2,189 @@ package main
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
 	"log"
-	"os"
+	"strings"
 )
 
-// ParseJSON Parse JSON file writing nodes to chan
-func ParseJSON(path string, ch chan<- Node, l *log.Logger) {
+// JSONRoot Path to the nodes within JSON inventories, nil for the top level
+var JSONRoot []string
+
+// ParseJSONRoot Split a -json-root path such as .data.hosts into keys
+func ParseJSONRoot(s string) []string {
+	s = strings.TrimPrefix(s, ".")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ".")
+}
+
+// ParseJSON Parse JSON inventory read from f writing nodes to chan. It holds
+// an array of nodes, or a stream of values such as newline delimited JSON
+// objects, each a node or an array of nodes. With JSONRoot the nodes are the
+// array, or the object keyed by node name, at that path in each value.
+func ParseJSON(path string, f io.Reader, ch chan<- Node, l *log.Logger) {
 	defer close(ch)
 
-	f, err := os.Open(path)
-	if err != nil {
+	if err := decodeJSON(path, f, ch, l); err != nil {
 		l.Printf("ERROR %v: %v\n", path, err)
-		return
 	}
-	defer f.Close()
+}
+
+// countingReader Reader counting the bytes read through it
+type countingReader struct {
+	r     io.Reader
+	count int64
+}
+
+// Read Read from the underlying reader, counting the bytes read
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// decodeJSON Stream the nodes of each JSON value read from r to chan
+func decodeJSON(path string, r io.Reader, ch chan<- Node, l *log.Logger) error {
+	input := &countingReader{r: r}
+	dec := json.NewDecoder(input)
+
+	for dec.More() {
+		if err := streamJSONRoot(path, dec, JSONRoot, ch, l); err != nil {
+			return jsonError(dec, input, err)
+		}
+	}
+
+	// More reports false on a syntax error as well as at the end of input
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("Unexpected closing delimiter")
+		}
+		return jsonError(dec, input, err)
+	}
+
+	return nil
+}
+
+// jsonError Add the input offset to a decoding error: where the syntax error
+// is, the end of input when it ended early, otherwise the decoder's position
+func jsonError(dec *json.Decoder, input *countingReader, err error) error {
+	var syntaxError *json.SyntaxError
+	if errors.As(err, &syntaxError) {
+		return fmt.Errorf("offset %d: %v", syntaxError.Offset, err)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("offset %d: %v", input.count, io.ErrUnexpectedEOF)
+	}
+
+	return fmt.Errorf("offset %d: %v", dec.InputOffset(), err)
+}
+
+// streamJSONRoot Stream the nodes at root within the next value, consuming
+// the rest of the value around them
+func streamJSONRoot(path string, dec *json.Decoder, root []string, ch chan<- Node, l *log.Logger) error {
+	if len(root) == 0 {
+		return streamJSONNodes(path, dec, len(JSONRoot) > 0, ch, l)
+	}
+
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("Expected an object containing %q, found %v", root[0], tok)
+	}
+
+	found := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if key, _ := tok.(string); key == root[0] && !found {
+			found = true
+			if err := streamJSONRoot(path, dec, root[1:], ch, l); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
 
-	fileBytes, err := ioutil.ReadAll(f)
+	if !found {
+		return fmt.Errorf("Key %q not found", root[0])
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// streamJSONNodes Stream the nodes of the next value: each item of an array,
+// each value of an object when keyed by node name, or else the object itself
+func streamJSONNodes(path string, dec *json.Decoder, keyed bool, ch chan<- Node, l *log.Logger) error {
+	tok, err := dec.Token()
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
-	nodes := make([]map[string](interface{}), 0)
-	json.Unmarshal(fileBytes, &nodes)
+	switch tok {
+	case json.Delim('['):
+		for index := 0; dec.More(); index++ {
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+
+			properties, ok := v.(map[string]interface{})
+			if !ok {
+				l.Printf("ERROR %v: offset %d: Item %d is not an object\n", path, dec.InputOffset(), index)
+				continue
+			}
+			ch <- NewNode(properties)
+		}
+	case json.Delim('{'):
+		object := make(map[string]interface{})
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+			if !keyed {
+				object[key] = v
+				continue
+			}
+
+			properties, ok := v.(map[string]interface{})
+			if v == nil {
+				properties, ok = make(map[string]interface{}), true
+			}
+			if !ok {
+				l.Printf("ERROR %v: offset %d: Node %q is not an object\n", path, dec.InputOffset(), key)
+				continue
+			}
+			if _, set := properties[NameKey]; !set {
+				properties[NameKey] = key
+			}
+			ch <- NewNode(properties)
+		}
 
-	for _, properties := range nodes {
-		n := NewNode(properties)
-		ch <- n
+		if !keyed {
+			ch <- NewNode(object)
+		}
+	default:
+		return fmt.Errorf("Expected an array or object of nodes, found %v", tok)
 	}
 
+	_, err = dec.Token()
+	return err
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// TestJSONErrorOffset Check decoding errors report where input ended or the
+// syntax error is
+func TestJSONErrorOffset(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`[{"node":"a"`, "offset 12: unexpected EOF"},
+		{"{\"node\":\"a\"}\n{\"node\":", "offset 21: unexpected EOF"},
+		{`{"node":x}`, "offset 9: invalid character 'x' looking for beginning of value"},
+	}
+
+	for _, test := range tests {
+		ch := make(chan Node)
+		go func() {
+			for range ch {
+			}
+		}()
+
+		err := decodeJSON("test", strings.NewReader(test.input), ch, log.New(io.Discard, "", 0))
+		close(ch)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("decodeJSON(%q) = %v, want %v", test.input, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MergePolicies Ways of resolving a property with different values in records
+// being merged
+var MergePolicies = map[string]bool{"first": true, "last": true, "error": true}
+
+// mergedNode A node being merged from records
+type mergedNode struct {
+	properties map[string]interface{}
+	origins    map[string]string // Source of each property
+	sources    []string          // Sources of the records, in order
+}
+
+// MergeNodes Join records sharing a value of key into single nodes, in order
+// of first appearance. Properties set to different values are resolved by
+// policy: first keeps the first value, last the last, and error reports every
+// conflict and fails. Records without key are reported and skipped, and nodes
+// not found in every source are reported.
+func MergeNodes(records []Node, key string, policy string, l *log.Logger) ([]Node, error) {
+	var order []string
+	merged := make(map[string]*mergedNode)
+	sources := make(map[string]bool)
+	conflicts := 0
+
+	for _, record := range records {
+		sources[record.Source] = true
+
+		v, err := record.GetProperty(&key)
+		if err != nil || v == nil || formatValue(v) == "" {
+			l.Printf("MERGE %v: Record without %v skipped: %v\n", record.Source, key, record.Properties)
+			continue
+		}
+		name := formatValue(v)
+
+		m, ok := merged[name]
+		if !ok {
+			m = &mergedNode{properties: make(map[string]interface{}), origins: make(map[string]string)}
+			merged[name] = m
+			order = append(order, name)
+		}
+		if !containsString(m.sources, record.Source) {
+			m.sources = append(m.sources, record.Source)
+		}
+
+		for k, v := range record.Properties {
+			current, set := m.properties[k]
+			switch {
+			case !set || policy == "last":
+			case reflect.DeepEqual(current, v) || policy == "first":
+				continue
+			default:
+				l.Printf("ERROR %v %v: %v is %v in %v and %v in %v\n", key, name, k, formatValue(current), m.origins[k], formatValue(v), record.Source)
+				conflicts++
+				continue
+			}
+			m.properties[k] = v
+			m.origins[k] = record.Source
+		}
+	}
+
+	if conflicts > 0 {
+		return nil, fmt.Errorf("%d conflicting properties", conflicts)
+	}
+
+	var nodes []Node
+	for _, name := range order {
+		m := merged[name]
+		if len(m.sources) < len(sources) {
+			var missing []string
+			for source := range sources {
+				if !containsString(m.sources, source) {
+					missing = append(missing, source)
+				}
+			}
+			sort.Strings(missing)
+			l.Printf("MERGE %v %v: Not found in %v\n", key, name, strings.Join(missing, ", "))
+		}
+
+		n := NewNode(m.properties)
+		n.Source = strings.Join(m.sources, ", ")
+		n.Origins = m.origins
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+// containsString Return true if items holds s
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
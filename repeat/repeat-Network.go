@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AddressRange Inclusive range of IPv4 or IPv6 addresses
+type AddressRange struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// ParseAddressRange Parse a CIDR prefix (10.0.0.0/8), a dash-separated range
+// (192.168.1.10-192.168.1.50) or a single address into an AddressRange
+func ParseAddressRange(s string) (AddressRange, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return AddressRange{}, err
+		}
+		prefix = prefix.Masked()
+
+		last := prefix.Addr().As16()
+		bits := prefix.Bits()
+		if prefix.Addr().Is4() {
+			bits += 96 // As16 stores IPv4 in the last four bytes
+		}
+		for i := bits; i < 128; i++ {
+			last[i/8] |= 1 << (7 - uint(i%8))
+		}
+
+		lastAddr := netip.AddrFrom16(last)
+		if prefix.Addr().Is4() {
+			lastAddr = lastAddr.Unmap()
+		}
+
+		return AddressRange{First: prefix.Addr(), Last: lastAddr}, nil
+	}
+
+	if index := strings.Index(s, "-"); index >= 0 {
+		first, err := netip.ParseAddr(strings.TrimSpace(s[:index]))
+		if err != nil {
+			return AddressRange{}, err
+		}
+
+		last, err := netip.ParseAddr(strings.TrimSpace(s[index+1:]))
+		if err != nil {
+			return AddressRange{}, err
+		}
+
+		first, last = first.Unmap(), last.Unmap()
+		if first.BitLen() != last.BitLen() {
+			return AddressRange{}, fmt.Errorf("Mixed address families in range %q", s)
+		}
+		if last.Less(first) {
+			return AddressRange{}, fmt.Errorf("Range end before start in %q", s)
+		}
+
+		return AddressRange{First: first, Last: last}, nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return AddressRange{}, err
+	}
+	addr = addr.Unmap()
+
+	return AddressRange{First: addr, Last: addr}, nil
+}
+
+// Contains Indicate if the address falls within the range
+func (r AddressRange) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	if addr.BitLen() != r.First.BitLen() {
+		return false
+	}
+
+	return !addr.Less(r.First) && !r.Last.Less(addr)
+}
+
+// ParseAddresses Extract every address from a property value. Values may be
+// lists, or strings holding several addresses separated by commas, semicolons
+// or whitespace. Addresses in CIDR notation (10.0.0.5/24) yield the address.
+// Items which do not parse as addresses are ignored.
+func ParseAddresses(v interface{}) []netip.Addr {
+	var addrs []netip.Addr
+
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			addrs = append(addrs, ParseAddresses(item)...)
+		}
+		return addrs
+	}
+
+	fields := strings.FieldsFunc(fmt.Sprintf("%v", v), func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+
+	for _, field := range fields {
+		if index := strings.Index(field, "/"); index >= 0 {
+			field = field[:index]
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
@@ -2,20 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"os/exec"
-	"strconv"
+	"regexp"
 	"strings"
-	"sync"
+	"text/template"
+	"time"
 )
 
 // Node Node information object
 type Node struct {
 	ID         uint32
 	Properties map[string]interface{}
+	Source     string            // Inventory the node was read from
+	Origins    map[string]string // Source of each property when merged or layered, nil for Source
 }
 
 // NewNode Creates and returns a new Node struct, optionally pre-setting properties
@@ -51,153 +55,231 @@ func (n Node) GetProperty(p *string) (interface{}, error) {
 	return v, nil
 }
 
-// Filter Indicate if the given node is in-scope based on passed filteres
-func (n Node) Filter(filters *[]Filter) bool {
-	for _, f := range *filters {
-		v, err := n.GetProperty(&f.Key)
-
-		if err != nil { // Handle missing properties
-			switch f.Comparator {
-			case "==": // key == value
-				return false
-			case "!=": // key != value
-				continue
-			}
+// Origin Return the source a property came from
+func (n Node) Origin(p string) string {
+	if origin, ok := n.Origins[p]; ok {
+		return origin
+	}
 
-			return false
-		}
+	return n.Source
+}
 
-		/* Check property against filter. To support multiple filters we only
-		test for conditions *NOT* met, returning false in such cases. Fall
-		through and return true if no conditions *NOT* met.  */
-
-		switch f.Type { // Route based on type
-		case "s": // Test as string
-			switch f.Comparator { // Route based on comparator
-			case "==":
-				if !strings.EqualFold(f.Value.(string), v.(string)) {
-					return false
-				}
-			case "!=":
-				if strings.EqualFold(f.Value.(string), v.(string)) {
-					return false
-				}
-			case ">=":
-				if !(len(v.(string)) >= len(f.Value.(string))) {
-					return false
-				}
-			case "<=":
-				if !(len(v.(string)) <= len(f.Value.(string))) {
-					return false
-				}
-			case "~=":
-				if -1 == strings.Index(strings.ToLower(v.(string)), strings.ToLower(f.Value.(string))) {
-					return false
-				}
-			}
-		case "f": // Test as float
-			vparsed, err := strconv.ParseFloat(fmt.Sprintf("%v", v), 64)
-			if err != nil {
-				return false
-			}
+// Filter Indicate if the given node is in-scope based on the passed filter
+// expression. A nil expression matches every node.
+func (n Node) Filter(e Expression) bool {
+	if e == nil {
+		return true
+	}
 
-			switch f.Comparator {
-			case "==":
-				if !(vparsed == f.Value.(float64)) {
-					return false
-				}
-			case "!=":
-				if vparsed == f.Value.(float64) {
-					return false
-				}
-			case ">=":
-				if !(vparsed >= f.Value.(float64)) {
-					return false
-				}
-			case "<=":
-				if !(vparsed <= f.Value.(float64)) {
-					return false
-				}
-			}
-		case "i": // Test as integer
-			vparsed, err := strconv.ParseInt(fmt.Sprintf("%v", v), 10, 64)
-			if err != nil {
-				return false
-			}
+	return e.Match(n)
+}
+
+// ProcessOptions Options controlling command execution
+type ProcessOptions struct {
+	Timeout   time.Duration // Per node deadline, 0 for none
+	WaitDelay time.Duration // Time allowed for output to drain after a command is killed
+
+	Retries        int            // Additional attempts after a failure
+	RetryDelay     time.Duration  // Delay before the first retry, doubled for each further retry
+	RetryMaxDelay  time.Duration  // Upper bound for retry delays, 0 for none
+	RetryExitCodes []int          // Retry only on these exit codes (-1 for timeouts), nil for any
+	RetryStderr    *regexp.Regexp // Retry only when stderr matches, nil for any
+
+	Templates []*template.Template // Command argument templates for -template, nil for ${} substitution
+	Quote     *Quoter              // Quoting for values substituted into shell scripts, nil for none
+	QuoteFrom int                  // Index of the first command argument Quote applies to
+	Stdin     bool                 // Write arguments from QuoteFrom on to the command's stdin as a script
+}
 
-			switch f.Comparator {
-			case "==":
-				if !(vparsed == f.Value.(int64)) {
-					return false
-				}
-			case "!=":
-				if vparsed == f.Value.(int64) {
-					return false
-				}
-			case ">=":
-				if !(vparsed >= f.Value.(int64)) {
-					return false
-				}
-			case "<=":
-				if !(vparsed <= f.Value.(int64)) {
-					return false
-				}
+// shouldRetry Indicate if a failed attempt qualifies for a retry
+func (o *ProcessOptions) shouldRetry(exitCode int, stderr string) bool {
+	if o.RetryExitCodes != nil {
+		found := false
+		for _, code := range o.RetryExitCodes {
+			if code == exitCode {
+				found = true
+				break
 			}
 		}
+		if !found {
+			return false
+		}
+	}
+
+	if o.RetryStderr != nil && !o.RetryStderr.MatchString(stderr) {
+		return false
 	}
 
 	return true
 }
 
-// Process Process node variables and execute command
-func (n Node) Process(c *[]string, async bool, l *log.Logger, wg *sync.WaitGroup) {
-	if async {
-		defer wg.Done()
+// retryDelay Return the exponential backoff delay before the given attempt,
+// jittered to between half and all of the computed delay
+func (o *ProcessOptions) retryDelay(attempt int) time.Duration {
+	d := o.RetryDelay
+	for i := 2; i < attempt; i++ {
+		d *= 2
+		if o.RetryMaxDelay > 0 && d >= o.RetryMaxDelay {
+			break
+		}
 	}
 
-	l.Printf("%X > %v\n", n.ID, n.Properties)
+	if o.RetryMaxDelay > 0 && d > o.RetryMaxDelay {
+		d = o.RetryMaxDelay
+	}
 
-	var myc []string
-	for _, subc := range *c {
-		for {
-			subcStart := strings.Index(subc, "${")
-			subcEnd := strings.Index(subc, "}")
-			if subcStart < 0 || subcEnd < 0 { // Break on no remaining subs
-				break
-			}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
 
-			subcName := subc[subcStart+2 : subcEnd]
-			subcValue, ok := n.Properties[subcName]
-			if !ok {
-				subcValue = ""
-			}
+// Render Substitute node variables into the command for the given attempt,
+// returning the command and the properties exposed to it, which include the
+// attempt number as _attempt
+func (n Node) Render(c *[]string, o *ProcessOptions, attempt int) ([]string, map[string]interface{}, error) {
+	properties := make(map[string]interface{}, len(n.Properties)+1)
+	for k, v := range n.Properties {
+		properties[k] = v
+	}
+	properties["_attempt"] = attempt
+
+	if o.Templates != nil {
+		argv, err := ExecuteTemplates(o.Templates, TemplateData{
+			ID:         fmt.Sprintf("%X", n.ID),
+			Properties: n.Properties,
+			Attempt:    attempt,
+		})
+		return argv, properties, err
+	}
 
-			subc = strings.Replace(subc, subc[subcStart:subcEnd+1], fmt.Sprintf("%v", subcValue), -1)
+	argv, err := Substitute(c, properties, o.Quote, o.QuoteFrom)
+	return argv, properties, err
+}
+
+// label Return the node's log line prefix, its ID followed by the attempt
+// number when retries are enabled
+func (n Node) label(o *ProcessOptions, attempt int) string {
+	if o.Retries > 0 {
+		return fmt.Sprintf("%X/%d", n.ID, attempt)
+	}
+
+	return fmt.Sprintf("%X", n.ID)
+}
+
+// Process Process node variables and execute command, retrying failures as
+// configured. The command's process group is killed when ctx is done or the
+// node times out.
+func (n Node) Process(ctx context.Context, c *[]string, o *ProcessOptions, l *log.Logger) Result {
+	l.Printf("%v > %v\n", n.label(o, 1), n.Properties)
+
+	r := Result{
+		ID:         fmt.Sprintf("%X", n.ID),
+		Properties: n.Properties,
+		Start:      time.Now(),
+	}
+
+	for attempt := 1; ; attempt++ {
+		var properties map[string]interface{}
+		var err error
+		r.Attempts = attempt
+		r.Argv, properties, err = n.Render(c, o, attempt)
+		if err != nil { // Substitution failures are not retried
+			r.ExitCode, r.Error = -1, err.Error()
+			l.Printf("%v ! %v\n", n.label(o, attempt), err)
+			break
+		}
+
+		n.execute(ctx, &r, properties, o, l, n.label(o, attempt))
+		if !r.Failed() || attempt > o.Retries || ctx.Err() != nil || !o.shouldRetry(r.ExitCode, r.Stderr) {
+			break
+		}
+
+		delay := o.retryDelay(attempt + 1)
+		l.Printf("%v R Attempt %d/%d in %v\n", n.label(o, attempt), attempt+1, o.Retries+1, delay.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if ctx.Err() != nil {
+			break
 		}
-		myc = append(myc, subc)
 	}
 
-	mycCommand := myc[0]
-	mycArgs := myc[1:]
+	r.End = time.Now()
+	r.Duration = r.End.Sub(r.Start).Seconds()
 
+	l.Printf("%v < %v\n", n.label(o, r.Attempts), r.ExitCode)
+
+	return r
+}
+
+// execute Run a single attempt of the rendered command in r.Argv, logging its
+// outcome and output under label and recording them in r. The exit code is -1
+// when the command was killed or not started.
+func (n Node) execute(ctx context.Context, r *Result, properties map[string]interface{}, o *ProcessOptions, l *log.Logger, label string) {
+	r.ExitCode, r.Stdout, r.Stderr, r.Error, r.TimedOut = -1, "", "", "", false
+
+	if len(r.Argv) == 0 {
+		r.Error = "No command given"
+		l.Printf("%v ! %v\n", label, r.Error)
+		return
+	}
+
+	nodeCtx := ctx
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		nodeCtx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	argv := r.Argv
 	var stdout, stderr bytes.Buffer
-	cmd := exec.Command(mycCommand, mycArgs...)
-	cmd.Env = BuildEnv(n.Properties)
+	if o.Stdin && o.QuoteFrom < len(argv) { // Script delivered on stdin
+		argv = r.Argv[:o.QuoteFrom]
+	}
+	cmd := exec.CommandContext(nodeCtx, argv[0], argv[1:]...)
+	if len(argv) < len(r.Argv) {
+		cmd.Stdin = strings.NewReader(strings.Join(r.Argv[o.QuoteFrom:], " ") + "\n")
+	}
+	cmd.Env = BuildEnv(properties)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = o.WaitDelay
 
-	err := cmd.Run()
-	if err != nil {
-		l.Printf("%X ! %v\n", n.ID, err)
+	err := cmd.Start()
+	if err == nil {
+		trackCommand(cmd)
+		err = cmd.Wait()
+		untrackCommand(cmd)
 	}
 
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		r.TimedOut = true
+		r.Error = "Run deadline exceeded"
+		l.Printf("%v T %v\n", label, r.Error)
+	case nodeCtx.Err() == context.DeadlineExceeded:
+		r.TimedOut = true
+		r.Error = fmt.Sprintf("Timed out after %v", o.Timeout)
+		l.Printf("%v T %v\n", label, r.Error)
+	case err != nil:
+		r.Error = err.Error()
+		l.Printf("%v ! %v\n", label, err)
+	}
+
+	r.Stdout = stdout.String()
+	r.Stderr = stderr.String()
+	r.ExitCode = cmd.ProcessState.ExitCode()
+
 	if stdout.Len() > 0 {
-		l.Printf("%X 1 %v\n", n.ID, strings.TrimSpace(stdout.String()))
+		l.Printf("%v 1 %v\n", label, strings.TrimSpace(r.Stdout))
 	}
 
 	if stderr.Len() > 0 {
-		l.Printf("%X 2 %v\n", n.ID, strings.TrimSpace(stderr.String()))
+		l.Printf("%v 2 %v\n", label, strings.TrimSpace(r.Stderr))
 	}
-
-	l.Printf("%X < %v\n", n.ID, cmd.ProcessState.ExitCode())
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Result Outcome of processing a node, as written by -output jsonl
+type Result struct {
+	ID         string                 `json:"id"`
+	Properties map[string]interface{} `json:"properties"`
+	Argv       []string               `json:"argv"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   float64                `json:"duration"` // Seconds
+	ExitCode   int                    `json:"exit_code"`
+	Stdout     string                 `json:"stdout"`
+	Stderr     string                 `json:"stderr"`
+	Error      string                 `json:"error,omitempty"`
+	TimedOut   bool                   `json:"timed_out"`
+	Attempts   int                    `json:"attempts"`
+}
+
+// Failed Indicate if the node did not complete successfully
+func (r Result) Failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+// ResultWriter Writes results as JSON Lines, safe for concurrent use
+type ResultWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewResultWriter Creates and returns a ResultWriter writing to w
+func NewResultWriter(w io.Writer) *ResultWriter {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	return &ResultWriter{encoder: encoder}
+}
+
+// Write Write a single record
+func (w *ResultWriter) Write(record interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.encoder.Encode(record)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Plan Command rendered for a node without running it, as written by
+// -dry-run with -output jsonl
+type Plan struct {
+	ID         string                 `json:"id"`
+	Properties map[string]interface{} `json:"properties"`
+	Argv       []string               `json:"argv"`
+	Env        []string               `json:"env"` // Added to the inherited environment
+	Error      string                 `json:"error,omitempty"`
+}
+
+// NewPlan Render the command for a node's first attempt
+func NewPlan(n Node, c *[]string, o *ProcessOptions) Plan {
+	argv, properties, err := n.Render(c, o, 1)
+
+	p := Plan{
+		ID:         fmt.Sprintf("%X", n.ID),
+		Properties: n.Properties,
+		Argv:       argv,
+		Env:        EnvDelta(properties),
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+
+	return p
+}
+
+// FormatArgv Join a command for display, quoting arguments which are empty
+// or contain whitespace or quotes
+func FormatArgv(argv []string) string {
+	var parts []string
+	for _, arg := range argv {
+		if arg == "" || strings.ContainsAny(arg, " \t\r\n\"'\\") {
+			arg = strconv.Quote(arg)
+		}
+		parts = append(parts, arg)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// WritePlans Write plans as an aligned table, or as JSON Lines records for
+// format jsonl
+func WritePlans(w io.Writer, plans []Plan, format string) error {
+	if format == "jsonl" {
+		rw := NewResultWriter(w)
+		for _, p := range plans {
+			if err := rw.Write(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCOMMAND\tENVIRONMENT")
+	for _, p := range plans {
+		command := FormatArgv(p.Argv)
+		if p.Error != "" {
+			command = "ERROR " + p.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.ID, command, FormatArgv(p.Env))
+	}
+
+	return tw.Flush()
+}
+
+// Confirm Prompt for a yes or no answer, returning true only for yes
+func Confirm(in io.Reader, out io.Writer, prompt string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", prompt)
+
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+
+	return false
+}
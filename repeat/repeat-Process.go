@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// runningCommands Commands currently executing, for signal forwarding. Once
+// signalled, finished commands stay tracked as their process groups may
+// outlive them.
+var runningCommands = struct {
+	sync.Mutex
+	commands  map[*exec.Cmd]struct{}
+	signalled bool
+}{commands: make(map[*exec.Cmd]struct{})}
+
+// trackCommand Register a started command for signal forwarding
+func trackCommand(cmd *exec.Cmd) {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+
+	runningCommands.commands[cmd] = struct{}{}
+}
+
+// untrackCommand Remove a finished command from signal forwarding
+func untrackCommand(cmd *exec.Cmd) {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+
+	if !runningCommands.signalled {
+		delete(runningCommands.commands, cmd)
+	}
+}
+
+// SignalCommands Forward a signal to the process groups of all running
+// commands, returning the number signalled
+func SignalCommands(sig os.Signal) int {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+
+	runningCommands.signalled = true
+	count := 0
+	for cmd := range runningCommands.commands {
+		if signalProcessGroup(cmd, sig) == nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+// KillCommands Kill the process groups of all tracked commands, including
+// those whose command exited after being signalled, returning the number
+// still running
+func KillCommands() int {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+
+	count := 0
+	for cmd := range runningCommands.commands {
+		if killProcessGroup(cmd) == nil {
+			count++
+		}
+		delete(runningCommands.commands, cmd)
+	}
+
+	return count
+}
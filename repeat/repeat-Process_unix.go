@@ -0,0 +1,44 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// terminalPath Console input, for prompts while stdin is in use
+const terminalPath = "/dev/tty"
+
+// isExecutable Return true if the file may be run as an inventory script
+func isExecutable(stat os.FileInfo) bool {
+	return stat.Mode().IsRegular() && stat.Mode().Perm()&0111 != 0
+}
+
+// setProcessGroup Start the command in a process group of its own so it and
+// its children can be signalled together
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup Send a signal to the command's process group
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		s = syscall.SIGTERM
+	}
+
+	return syscall.Kill(-cmd.Process.Pid, s)
+}
+
+// killProcessGroup Kill the command's process group
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// shellCommand Return a command running script with the system shell
+func shellCommand(ctx context.Context, script string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", script)
+}
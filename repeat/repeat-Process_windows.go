@@ -0,0 +1,54 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var generateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// terminalPath Console input, for prompts while stdin is in use
+const terminalPath = "CONIN$"
+
+// isExecutable Return true if the file may be run as an inventory script
+func isExecutable(stat os.FileInfo) bool {
+	switch strings.ToLower(filepath.Ext(stat.Name())) {
+	case ".exe", ".com", ".bat", ".cmd":
+		return stat.Mode().IsRegular()
+	}
+	return false
+}
+
+// setProcessGroup Start the command in a process group of its own so it and
+// its children can be signalled together
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalProcessGroup Send CTRL_BREAK to the command's process group, the
+// closest console equivalent of SIGINT and SIGTERM
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	r, _, err := generateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(cmd.Process.Pid))
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// killProcessGroup Kill the command and its child processes
+func killProcessGroup(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
+
+// shellCommand Return a command running script with the system shell
+func shellCommand(ctx context.Context, script string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd.exe", "/C", script)
+}
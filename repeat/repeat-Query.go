@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ExportFormats Formats matched nodes can be exported in
+var ExportFormats = map[string]bool{"csv": true, "json": true, "ndjson": true}
+
+// Query Report on matched nodes run instead of the command
+type Query struct {
+	Mode    string   // list, count, distinct or export
+	Key     string   // Property counted by distinct
+	Format  string   // Export format, csv, json or ndjson
+	Columns []string // Properties listed or exported, nil for all
+}
+
+// Run Write the query's report on nodes to w
+func (q Query) Run(w io.Writer, nodes []Node) error {
+	switch q.Mode {
+	case "list":
+		return q.list(w, nodes)
+	case "count":
+		_, err := fmt.Fprintln(w, len(nodes))
+		return err
+	case "distinct":
+		return q.distinct(w, nodes)
+	case "export":
+		return q.export(w, nodes)
+	}
+
+	return fmt.Errorf("Unknown query %q", q.Mode)
+}
+
+// columns Return the chosen columns, or every property of the nodes sorted
+// with the node name first when any node has one
+func (q Query) columns(nodes []Node) []string {
+	if q.Columns != nil {
+		return q.Columns
+	}
+
+	seen := make(map[string]bool)
+	var columns []string
+	for _, n := range nodes {
+		for key := range n.Properties {
+			if !seen[key] {
+				seen[key] = true
+				if key != NameKey {
+					columns = append(columns, key)
+				}
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	if seen[NameKey] {
+		columns = append([]string{NameKey}, columns...)
+	}
+	return columns
+}
+
+// cell Return a node's column value formatted for a table or CSV, with lists
+// of plain values joined by ; as -csv-schema list columns read them, and ""
+// when unset
+func cell(n Node, column string) string {
+	v, err := n.GetProperty(&column)
+	if err != nil || v == nil {
+		return ""
+	}
+
+	if items, ok := v.([]interface{}); ok {
+		var parts []string
+		for _, item := range items {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				return formatValue(v)
+			}
+			parts = append(parts, formatValue(item))
+		}
+		return strings.Join(parts, ";")
+	}
+
+	return formatValue(v)
+}
+
+// list Write nodes as an aligned table of the chosen columns
+func (q Query) list(w io.Writer, nodes []Node) error {
+	columns := q.columns(nodes)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, n := range nodes {
+		var cells []string
+		for _, column := range columns {
+			cells = append(cells, cell(n, column))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// distinct Write each value of the key property with the number of nodes
+// holding it, most common first. Nodes count once for each item of a list.
+func (q Query) distinct(w io.Writer, nodes []Node) error {
+	counts := make(map[string]int)
+	for _, n := range nodes {
+		v, err := n.GetProperty(&q.Key)
+		if err != nil || v == nil {
+			counts["(unset)"]++
+			continue
+		}
+
+		items, ok := v.([]interface{})
+		if !ok {
+			items = []interface{}{v}
+		}
+		for _, item := range items {
+			counts[formatValue(item)]++
+		}
+	}
+
+	var values []string
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tCOUNT\n", strings.ToUpper(q.Key))
+	for _, value := range values {
+		fmt.Fprintf(tw, "%s\t%d\n", value, counts[value])
+	}
+
+	return tw.Flush()
+}
+
+// export Write nodes as CSV with a header row of the chosen columns, a JSON
+// array or newline delimited JSON of their properties, limited to the chosen
+// columns when given
+func (q Query) export(w io.Writer, nodes []Node) error {
+	if q.Format == "csv" {
+		columns := q.columns(nodes)
+
+		cw := csv.NewWriter(w)
+		cw.Write(columns)
+		for _, n := range nodes {
+			var cells []string
+			for _, column := range columns {
+				cells = append(cells, cell(n, column))
+			}
+			cw.Write(cells)
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	records := make([]map[string]interface{}, 0, len(nodes))
+	for _, n := range nodes {
+		properties := n.Properties
+		if q.Columns != nil {
+			properties = make(map[string]interface{}, len(q.Columns))
+			for _, column := range q.Columns {
+				if v, err := n.GetProperty(&column); err == nil {
+					properties[column] = v
+				}
+			}
+		}
+		records = append(records, properties)
+	}
+
+	if q.Format == "ndjson" {
+		rw := NewResultWriter(w)
+		for _, record := range records {
+			if err := rw.Write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestQueryExportCSV Check CSV exports hold only properties the nodes have,
+// with the node name first
+func TestQueryExportCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []Node
+		want  string
+	}{
+		{"named", []Node{
+			NewNode(map[string]interface{}{"node": "a", "address": "10.0.0.1", "tags": []interface{}{"web", "prod"}}),
+			NewNode(map[string]interface{}{"node": "b"}),
+		}, "node,address,tags\na,10.0.0.1,web;prod\nb,,\n"},
+		{"unnamed", []Node{
+			NewNode(map[string]interface{}{"col1": "a", "col2": "b"}),
+		}, "col1,col2\na,b\n"},
+	}
+
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := (Query{Mode: "export", Format: "csv"}).Run(&b, test.nodes); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.want {
+			t.Errorf("%v: export = %q, want %q", test.name, b.String(), test.want)
+		}
+	}
+}
+
+// TestQueryDistinct Check list items are counted separately, most common
+// first
+func TestQueryDistinct(t *testing.T) {
+	nodes := []Node{
+		NewNode(map[string]interface{}{"tags": []interface{}{"web", "prod"}}),
+		NewNode(map[string]interface{}{"tags": []interface{}{"web"}}),
+		NewNode(nil),
+	}
+
+	var b bytes.Buffer
+	if err := (Query{Mode: "distinct", Key: "tags"}).Run(&b, nodes); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Fields(b.String()), []string{"TAGS", "COUNT", "web", "2", "(unset)", "1", "prod", "1"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("distinct = %q", b.String())
+	}
+}
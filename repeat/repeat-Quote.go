@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// quotedString Characters opening and closing a kind of quoted string in a
+// shell's grammar, and whether the escape character works inside it
+type quotedString struct {
+	quotes  string
+	escapes bool
+}
+
+// Quoter Quoting rule for values substituted into shell scripts, with the
+// quoted strings and escape character of the shell's grammar
+type Quoter struct {
+	Quote   func(string) string // Quote a value as a single word
+	strings []quotedString
+	escape  rune
+}
+
+// Quoters Quoting rules for values substituted into shell scripts, by name
+var Quoters = map[string]Quoter{
+	"posix":      {QuotePOSIX, []quotedString{{"'", false}, {`"`, true}}, '\\'},
+	"cmd":        {QuoteCmd, []quotedString{{`"`, false}}, '^'},
+	"powershell": {QuotePowerShell, []quotedString{{"'‘’‚‛", false}, {`"“”„`, true}}, '`'},
+	"python":     {strconv.Quote, []quotedString{{"'", true}, {`"`, true}}, '\\'}, // Go escapes are a subset of Python 3 string literal escapes
+}
+
+// inString Indicate if a quoted string is left open at the end of script s
+func (q *Quoter) inString(s string) bool {
+	open, escaped := -1, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == q.escape && (open < 0 || q.strings[open].escapes):
+			escaped = true
+		case open >= 0:
+			if strings.ContainsRune(q.strings[open].quotes, r) {
+				open = -1
+			}
+		default:
+			for index, str := range q.strings {
+				if strings.ContainsRune(str.quotes, r) {
+					open = index
+					break
+				}
+			}
+		}
+	}
+
+	return open >= 0
+}
+
+// QuotePOSIX Quote a string as a single POSIX shell word
+func QuotePOSIX(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// QuotePowerShell Quote a string as a PowerShell verbatim string. PowerShell
+// accepts typographic single quotes as quote characters too, so those are
+// doubled along with '.
+func QuotePowerShell(s string) string {
+	var b strings.Builder
+
+	b.WriteRune('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '‘', '’', '‚', '‛':
+			b.WriteRune(r)
+		}
+		b.WriteRune(r)
+	}
+	b.WriteRune('\'')
+
+	return b.String()
+}
+
+// quoteWindowsArgument Quote a string as a single argument for the
+// CommandLineToArgvW rules most Windows programs parse their arguments with
+func quoteWindowsArgument(s string) string {
+	var b strings.Builder
+
+	b.WriteRune('"')
+	backslashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"': // Escape preceding backslashes and the quote
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes*2)) // Before the closing quote
+	b.WriteRune('"')
+
+	return b.String()
+}
+
+// QuoteCmd Quote a string as a single argument on a cmd.exe command line. The
+// argument is quoted for CommandLineToArgvW, then every cmd.exe metacharacter,
+// quotes included, is escaped with ^ so cmd.exe passes it through untouched.
+// Escaping % leaves a ^ inside any %NAME% pair, so no variable expands.
+func QuoteCmd(s string) string {
+	var b strings.Builder
+
+	for _, r := range quoteWindowsArgument(s) {
+		switch r {
+		case '(', ')', '%', '!', '^', '"', '<', '>', '&', '|':
+			b.WriteRune('^')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
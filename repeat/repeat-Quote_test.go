@@ -0,0 +1,251 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// hostileValues Property values which break out of naive quoting
+var hostileValues = []string{
+	"",
+	"plain",
+	"a; touch /tmp/pwned",
+	"$(touch /tmp/pwned)",
+	"`touch /tmp/pwned`",
+	"it's",
+	"'",
+	"''",
+	`say "hi"`,
+	`"`,
+	"%PATH%",
+	"%%",
+	"^",
+	"a & b",
+	"a | b",
+	"a && b || c",
+	"<in >out",
+	"(sub)",
+	"!bang!",
+	"line\nbreak",
+	"crlf\r\n",
+	"tab\there",
+	`trailing\`,
+	`trailing\\`,
+	`\"`,
+	"‘typographic’",
+	"“typographic”",
+	"‚low‛",
+	"$HOME ${HOME}",
+	"*",
+	"~",
+	"#comment",
+	"é ü 日本",
+}
+
+// TestQuotePOSIX Check quoted values come back byte for byte from sh -c
+func TestQuotePOSIX(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is not available")
+	}
+
+	for _, value := range hostileValues {
+		script := "printf %s " + QuotePOSIX(value)
+		out, err := exec.Command("sh", "-c", script).Output()
+		if err != nil {
+			t.Errorf("QuotePOSIX(%q): sh -c %q: %v", value, script, err)
+			continue
+		}
+		if string(out) != value {
+			t.Errorf("QuotePOSIX(%q): sh printed %q", value, out)
+		}
+	}
+}
+
+// TestQuoteCmd Check quoted values against known results, and that every
+// cmd.exe metacharacter is escaped
+func TestQuoteCmd(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", `^"^"`},
+		{"plain", `^"plain^"`},
+		{"a & b", `^"a ^& b^"`},
+		{"a | b", `^"a ^| b^"`},
+		{"%PATH%", `^"^%PATH^%^"`},
+		{"^", `^"^^^"`},
+		{`say "hi"`, `^"say \^"hi\^"^"`},
+		{`trailing\`, `^"trailing\\^"`},
+		{`\"`, `^"\\\^"^"`},
+		{"(sub) <in >out !x!", `^"^(sub^) ^<in ^>out ^!x^!^"`},
+	}
+
+	for _, test := range tests {
+		if got := QuoteCmd(test.value); got != test.want {
+			t.Errorf("QuoteCmd(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range hostileValues {
+		quoted := QuoteCmd(value)
+		for index := 0; index < len(quoted); index++ {
+			if quoted[index] == '^' {
+				index++ // Escaped character
+				continue
+			}
+			if strings.IndexByte(`()%!"<>&|`, quoted[index]) >= 0 {
+				t.Errorf("QuoteCmd(%q) = %q: unescaped %q at %d", value, quoted, quoted[index], index)
+			}
+		}
+	}
+}
+
+// TestQuoteWindowsArgument Check quoted values parse back with the
+// CommandLineToArgvW rules
+func TestQuoteWindowsArgument(t *testing.T) {
+	for _, value := range hostileValues {
+		if got := parseWindowsArgument(quoteWindowsArgument(value)); got != value {
+			t.Errorf("quoteWindowsArgument(%q) = %q, parsed back as %q", value, quoteWindowsArgument(value), got)
+		}
+	}
+}
+
+// parseWindowsArgument Parse a single quoted argument as CommandLineToArgvW does
+func parseWindowsArgument(s string) string {
+	var b strings.Builder
+	quoted, backslashes := false, 0
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			backslashes++
+			continue
+		case r == '"':
+			b.WriteString(strings.Repeat(`\`, backslashes/2))
+			if backslashes%2 == 1 {
+				b.WriteRune('"')
+			} else {
+				quoted = !quoted
+			}
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+			b.WriteRune(r)
+		}
+		backslashes = 0
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes))
+
+	return b.String()
+}
+
+// TestQuotePowerShell Check quoted values against known results, and that no
+// single quote of any kind ends the string early
+func TestQuotePowerShell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", "''"},
+		{"it's", "'it''s'"},
+		{"$(touch /tmp/pwned)", "'$(touch /tmp/pwned)'"},
+		{"`n", "'`n'"},
+		{"‘typographic’", "'‘‘typographic’’'"},
+		{"‚low‛", "'‚‚low‛‛'"},
+		{`say "hi"`, `'say "hi"'`},
+		{"line\nbreak", "'line\nbreak'"},
+	}
+
+	for _, test := range tests {
+		if got := QuotePowerShell(test.value); got != test.want {
+			t.Errorf("QuotePowerShell(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range hostileValues {
+		quoted := []rune(QuotePowerShell(value))
+		inner := quoted[1 : len(quoted)-1]
+		for index := 0; index < len(inner); index++ {
+			if strings.ContainsRune("'‘’‚‛", inner[index]) {
+				if index+1 >= len(inner) || inner[index+1] != inner[index] {
+					t.Errorf("QuotePowerShell(%q) = %q: undoubled quote at %d", value, string(quoted), index)
+				}
+				index++
+			}
+		}
+	}
+}
+
+// TestQuotePython Check quoted values come back byte for byte from python3
+func TestQuotePython(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 is not available")
+	}
+
+	for _, value := range hostileValues {
+		script := "import sys; sys.stdout.buffer.write(" + Quoters["python"].Quote(value) + ".encode())"
+		out, err := exec.Command(python, "-c", script).Output()
+		if err != nil {
+			t.Errorf("python quote %q: python3 -c %q: %v", value, script, err)
+			continue
+		}
+		if string(out) != value {
+			t.Errorf("python quote %q: python3 printed %q", value, out)
+		}
+	}
+}
+
+// TestSubstituteQuotesShellArguments Check values substituted into a shell
+// script are quoted, and arguments before QuoteFrom are not
+func TestSubstituteQuotesShellArguments(t *testing.T) {
+	c := []string{"sh", "-c", "echo ${node}"}
+	properties := map[string]interface{}{"node": "a; touch /tmp/pwned"}
+
+	posix := Quoters["posix"]
+	argv, err := Substitute(&c, properties, &posix, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `echo 'a; touch /tmp/pwned'`; argv[2] != want {
+		t.Errorf("Substitute = %q, want %q", argv[2], want)
+	}
+}
+
+// TestSubstituteRejectsQuotedReferences Check values are never substituted
+// inside the script's own quotes, where their quoting would end the string
+func TestSubstituteRejectsQuotedReferences(t *testing.T) {
+	tests := []struct {
+		quoter string
+		script string
+		value  string
+		ok     bool
+	}{
+		{"posix", "echo '${node}'", "a; echo INJECTED", false},
+		{"posix", `echo "${node}"`, "$(echo INJECTED)", false},
+		{"posix", `echo "${node|shellquote}"`, "a", false},
+		{"posix", `echo "${node|raw}"`, "a", true},
+		{"posix", `echo "it's" ${node}`, "a", true},
+		{"posix", `echo \' ${node}`, "a", true},
+		{"posix", `echo '\' ${node}`, "a", true},
+		{"posix", `echo "\"" ${node}`, "a", true},
+		{"posix", `echo ${node} '${node}'`, "it's", false},
+		{"cmd", `echo it's ${node}`, "a", true},
+		{"cmd", `echo "${node}"`, "a", false},
+		{"cmd", `echo ^" ${node}`, "a", true},
+		{"powershell", `echo 'it''s' ${node}`, "a", true},
+		{"powershell", "echo \"`\"\" ${node}", "a", true},
+		{"powershell", "echo ‘${node}’", "a", false},
+		{"python", `print('\'', ${node})`, "a", true},
+		{"python", `print(f"{${node}}")`, "a", false},
+	}
+
+	for _, test := range tests {
+		quoter := Quoters[test.quoter]
+		n := Node{Properties: map[string]interface{}{"node": test.value}}
+		got, err := SubstituteString(test.script, n, &quoter)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%v SubstituteString(%q) = %q, %v, want ok %v", test.quoter, test.script, got, err, test.ok)
+		}
+	}
+}
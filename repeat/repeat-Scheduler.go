@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Scheduler Bounded pool processing submitted nodes. Nodes are started in
+// submission order, skipping over nodes whose groups are at their limit.
+type Scheduler struct {
+	Parallel    int            // Maximum nodes processed concurrently
+	GroupLimits map[string]int // Maximum nodes processed concurrently per value of a property
+
+	process func(Node)
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []Node
+	skipped []Node // Nodes dropped by Stop, never processed
+	count   int    // Nodes submitted
+	running int
+	groups  map[string]int // Running nodes per property=value
+	closed  bool
+	stopped bool
+	done    chan struct{}
+}
+
+// NewScheduler Creates and returns a started Scheduler calling process for
+// each submitted node
+func NewScheduler(parallel int, groupLimits map[string]int, process func(Node)) *Scheduler {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	s := &Scheduler{
+		Parallel:    parallel,
+		GroupLimits: groupLimits,
+		process:     process,
+		groups:      make(map[string]int),
+		done:        make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	go s.dispatch()
+
+	return s
+}
+
+// Submit Queue a node for processing
+func (s *Scheduler) Submit(n Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.count++
+	if s.stopped {
+		s.skipped = append(s.skipped, n)
+		return
+	}
+
+	s.pending = append(s.pending, n)
+	s.cond.Broadcast()
+}
+
+// Stop Drop queued nodes and any nodes submitted later, letting running nodes
+// finish
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	s.skipped = append(s.skipped, s.pending...)
+	s.pending = nil
+	s.cond.Broadcast()
+}
+
+// Stopped Indicate if Stop has been called
+func (s *Scheduler) Stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stopped
+}
+
+// Drain Wait until no nodes are queued or running, leaving the scheduler open
+// for further nodes
+func (s *Scheduler) Drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.pending) > 0 || s.running > 0 {
+		s.cond.Wait()
+	}
+}
+
+// Submitted Return the number of nodes submitted so far
+func (s *Scheduler) Submitted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.count
+}
+
+// Skipped Return nodes dropped by Stop
+func (s *Scheduler) Skipped() []Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Node(nil), s.skipped...)
+}
+
+// Wait Stop accepting nodes and wait for all queued nodes to be processed
+func (s *Scheduler) Wait() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	<-s.done
+}
+
+// groupKeys Return the property=value group keys a node counts against
+func (s *Scheduler) groupKeys(n Node) []string {
+	var keys []string
+
+	for property := range s.GroupLimits {
+		v, err := n.GetProperty(&property)
+		if err != nil { // Nodes without the property are not limited by it
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("%s=%v", property, v))
+	}
+
+	return keys
+}
+
+// runnable Return the index of the first pending node which may start, or -1
+func (s *Scheduler) runnable() int {
+	if s.running >= s.Parallel {
+		return -1
+	}
+
+	for index, n := range s.pending {
+		ok := true
+		for property, limit := range s.GroupLimits {
+			v, err := n.GetProperty(&property)
+			if err == nil && s.groups[fmt.Sprintf("%s=%v", property, v)] >= limit {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// dispatch Start pending nodes as capacity allows until closed and drained
+func (s *Scheduler) dispatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.closed && len(s.pending) == 0 && s.running == 0 {
+			close(s.done)
+			return
+		}
+
+		index := s.runnable()
+		if index < 0 {
+			s.cond.Wait()
+			continue
+		}
+
+		n := s.pending[index]
+		s.pending = append(s.pending[:index], s.pending[index+1:]...)
+
+		keys := s.groupKeys(n)
+		for _, key := range keys {
+			s.groups[key]++
+		}
+		s.running++
+
+		go func() {
+			s.process(n)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			for _, key := range keys {
+				s.groups[key]--
+			}
+			s.running--
+			s.cond.Broadcast()
+		}()
+	}
+}
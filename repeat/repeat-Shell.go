@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ShellProfile Interpreter a command is handed to as a script
+type ShellProfile struct {
+	Argv     []string `json:"argv"`     // Interpreter and arguments preceding the script
+	Quote    string   `json:"quote"`    // Quoting rule for substituted values, a Quoters name or none
+	Delivery string   `json:"delivery"` // argument, appending the script to Argv, or stdin
+}
+
+// BuiltinShellProfiles Shell profiles available without a config file
+var BuiltinShellProfiles = map[string]ShellProfile{
+	"sh":          {Argv: []string{"sh", "-c"}, Quote: "posix", Delivery: "argument"},
+	"bash":        {Argv: []string{"bash", "-c"}, Quote: "posix", Delivery: "argument"},
+	"bash-strict": {Argv: []string{"bash", "-euo", "pipefail", "-c"}, Quote: "posix", Delivery: "argument"},
+	"zsh":         {Argv: []string{"zsh", "-c"}, Quote: "posix", Delivery: "argument"},
+	"cmd":         {Argv: []string{"cmd.exe", "/C"}, Quote: "cmd", Delivery: "argument"},
+	"ps":          {Argv: []string{"powershell.exe", "-Command"}, Quote: "powershell", Delivery: "argument"},
+	"pwsh":        {Argv: []string{"pwsh.exe", "-Command"}, Quote: "powershell", Delivery: "argument"},
+	"python":      {Argv: []string{"python3", "-"}, Quote: "python", Delivery: "stdin"},
+}
+
+// DefaultShellConfig Return the default shell profile config file location
+func DefaultShellConfig() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "repeat", "shells.json")
+}
+
+// LoadShellProfiles Return the built-in shell profiles overlaid with those in
+// the JSON config file at path, an object of profiles keyed by name. A
+// missing file is only an error when required.
+func LoadShellProfiles(path string, required bool) (map[string]ShellProfile, error) {
+	profiles := make(map[string]ShellProfile, len(BuiltinShellProfiles))
+	for name, p := range BuiltinShellProfiles {
+		profiles[name] = p
+	}
+
+	if path == "" {
+		return profiles, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	custom := make(map[string]ShellProfile)
+	if err := json.Unmarshal(b, &custom); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	for name, p := range custom {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("%v: shell %q: %v", path, name, err)
+		}
+		profiles[name] = p
+	}
+
+	return profiles, nil
+}
+
+// validate Check a profile is usable, defaulting its delivery to argument
+func (p *ShellProfile) validate() error {
+	if len(p.Argv) == 0 {
+		return errors.New("argv is empty")
+	}
+
+	if _, ok := Quoters[p.Quote]; !ok && p.Quote != "none" && p.Quote != "" {
+		return fmt.Errorf("Unknown quote rule %q", p.Quote)
+	}
+
+	switch p.Delivery {
+	case "":
+		p.Delivery = "argument"
+	case "argument", "stdin":
+	default:
+		return fmt.Errorf("Unknown delivery %q", p.Delivery)
+	}
+
+	return nil
+}
+
+// ShellNames Return the sorted names of the given profiles
+func ShellNames(profiles map[string]ShellProfile) []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Pipes Transforms available to ${VARIABLE|pipe} substitutions
+var Pipes = map[string]func(string) string{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"shellquote": QuotePOSIX,
+	"cmdquote":   QuoteCmd,
+	"psquote":    QuotePowerShell,
+	"urlencode":  url.QueryEscape,
+	"raw":        func(s string) string { return s },
+}
+
+// quotingPipes Pipes which take over from automatic quoting
+var quotingPipes = map[string]bool{"raw": true, "shellquote": true, "cmdquote": true, "psquote": true}
+
+// formatValue Format a property value for substitution. Lists and maps are
+// written as JSON, everything else as with %v.
+func formatValue(v interface{}) string {
+	switch v.(type) {
+	case []interface{}, map[string]interface{}:
+		b, err := json.Marshal(v)
+		if err == nil {
+			return string(b)
+		}
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// expand Evaluate the body of a ${...} substitution: a dotted property path,
+// an optional :-default or :?error modifier, then any |pipe transforms. The
+// result is passed to quote unless a pipe already quoted it or asked for raw,
+// and reported as quoted unless raw.
+func expand(body string, n Node, quote func(string) string) (string, bool, error) {
+	pipes := strings.Split(body, "|")
+	expression := pipes[0]
+
+	name, modifier, operand := expression, "", ""
+	if index := strings.Index(expression, ":"); index >= 0 && index+1 < len(expression) {
+		switch expression[index+1] {
+		case '-', '?':
+			name, modifier, operand = expression[:index], expression[index:index+2], expression[index+2:]
+		}
+	}
+
+	value := ""
+	v, err := n.GetProperty(&name)
+	if err == nil {
+		value = formatValue(v)
+	}
+
+	if value == "" {
+		switch modifier {
+		case ":-":
+			value = operand
+		case ":?":
+			if operand == "" {
+				operand = "Property not set"
+			}
+			return "", false, fmt.Errorf("%s: %s", name, operand)
+		}
+	}
+
+	quoted := quote != nil
+	for _, pipe := range pipes[1:] {
+		pipe = strings.TrimSpace(pipe)
+		transform, ok := Pipes[pipe]
+		if !ok {
+			return "", false, fmt.Errorf("Unknown substitution pipe %q", pipe)
+		}
+		value = transform(value)
+
+		if quotingPipes[pipe] {
+			quote, quoted = nil, pipe != "raw"
+		}
+	}
+
+	if quote != nil {
+		value = quote(value)
+	}
+
+	return value, quoted, nil
+}
+
+// SubstituteString Replace ${VARIABLE} references in s with property values,
+// quoted as shell words when quoter is not nil. Quoted values would run
+// together with a quoted string around them, so references inside one are
+// rejected unless raw. $${ produces a literal ${.
+func SubstituteString(s string, n Node, quoter *Quoter) (string, error) {
+	var quote func(string) string
+	if quoter != nil {
+		quote = quoter.Quote
+	}
+
+	var b strings.Builder
+
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			break
+		}
+
+		if start > 0 && s[start-1] == '$' { // Escaped, $${ becomes ${
+			b.WriteString(s[:start-1])
+			b.WriteString("${")
+			s = s[start+2:]
+			continue
+		}
+
+		end := strings.Index(s[start:], "}")
+		if end < 0 { // Unterminated, leave as is
+			b.WriteString(s)
+			break
+		}
+		end += start
+
+		value, quoted, err := expand(s[start+2:end], n, quote)
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(s[:start])
+		if quoter != nil && quoted && quoter.inString(b.String()) {
+			return "", fmt.Errorf("Substitution %s inside quotes: values are quoted for the shell, remove the quotes or use |raw", s[start:end+1])
+		}
+		b.WriteString(value)
+		s = s[end+1:]
+	}
+
+	return b.String(), nil
+}
+
+// Substitute Replace ${VARIABLE} references in each command argument with
+// property values. Values substituted into arguments from index quoteFrom on
+// are quoted with quoter when not nil.
+func Substitute(c *[]string, properties map[string]interface{}, quoter *Quoter, quoteFrom int) ([]string, error) {
+	n := Node{Properties: properties}
+
+	var myc []string
+	for index, subc := range *c {
+		q := quoter
+		if index < quoteFrom {
+			q = nil
+		}
+
+		subc, err := SubstituteString(subc, n, q)
+		if err != nil {
+			return nil, err
+		}
+		myc = append(myc, subc)
+	}
+
+	return myc, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Summary End-of-run tallies of node results, safe for concurrent use
+type Summary struct {
+	mu        sync.Mutex
+	Matched   int
+	Succeeded int
+	Failed    int // Includes timed out nodes
+	TimedOut  int
+	Skipped   int // Matched but never started
+
+	InventoryErrors int // Sources, files and rows which could not be read
+	durations       []float64
+	failures        []Result
+	skipped         []Node
+}
+
+// Add Tally a processed node's result
+func (s *Summary) Add(r Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Matched++
+	s.durations = append(s.durations, r.Duration)
+
+	if !r.Failed() {
+		s.Succeeded++
+		return
+	}
+
+	s.Failed++
+	if r.TimedOut {
+		s.TimedOut++
+	}
+	s.failures = append(s.failures, r)
+}
+
+// Skip Tally nodes which matched but were never started
+func (s *Summary) Skip(nodes []Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Matched += len(nodes)
+	s.Skipped += len(nodes)
+	s.skipped = append(s.skipped, nodes...)
+}
+
+// Exceeds Indicate if failures have reached maxFailures, or maxPercent of
+// the matched nodes. Zero limits are ignored, and maxPercent while matched
+// is not yet known and 0.
+func (s *Summary) Exceeds(maxFailures int, maxPercent float64, matched int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if maxFailures > 0 && s.Failed >= maxFailures {
+		return true
+	}
+
+	if maxPercent > 0 && matched > 0 && float64(s.Failed)*100/float64(matched) >= maxPercent {
+		return true
+	}
+
+	return false
+}
+
+// percentile Return the p-th percentile of sorted values, nearest-rank
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// describe Format a node ID with the given key properties for reports
+func describe(id string, properties map[string]interface{}, keys []string) string {
+	parts := []string{id}
+	n := Node{Properties: properties}
+
+	for _, key := range keys {
+		if v, err := n.GetProperty(&key); err == nil {
+			parts = append(parts, fmt.Sprintf("%s=%v", key, v))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Report Log the summary, listing failed and skipped nodes with the given
+// key properties
+func (s *Summary) Report(l *log.Logger, keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l.Printf("SUMMARY matched %d, succeeded %d, failed %d, timed out %d, skipped %d\n", s.Matched, s.Succeeded, s.Failed, s.TimedOut, s.Skipped)
+	if s.InventoryErrors > 0 {
+		l.Printf("SUMMARY inventory errors %d\n", s.InventoryErrors)
+	}
+
+	if len(s.durations) > 0 {
+		sorted := append([]float64(nil), s.durations...)
+		sort.Float64s(sorted)
+
+		total := 0.0
+		for _, d := range sorted {
+			total += d
+		}
+
+		seconds := func(v float64) time.Duration {
+			return time.Duration(v * float64(time.Second)).Round(time.Millisecond)
+		}
+		l.Printf("SUMMARY duration total %v, p50 %v, p90 %v, p99 %v, max %v\n", seconds(total), seconds(percentile(sorted, 50)), seconds(percentile(sorted, 90)), seconds(percentile(sorted, 99)), seconds(sorted[len(sorted)-1]))
+	}
+
+	for _, r := range s.failures {
+		reason := r.Error
+		if reason == "" {
+			reason = fmt.Sprintf("exit %d", r.ExitCode)
+		}
+		l.Printf("SUMMARY failed %s: %s\n", describe(r.ID, r.Properties, keys), reason)
+	}
+
+	for _, n := range s.skipped {
+		l.Printf("SUMMARY skipped %s\n", describe(fmt.Sprintf("%X", n.ID), n.Properties, keys))
+	}
+}
+
+// FailPolicy Decides whether a run exits non-zero
+type FailPolicy struct {
+	Mode      string  // none, any, all or threshold
+	Threshold float64 // Percent of matched nodes for threshold
+}
+
+// ParseFailPolicy Parse none, any, all or threshold=N%
+func ParseFailPolicy(s string) (FailPolicy, error) {
+	switch s {
+	case "none", "any", "all":
+		return FailPolicy{Mode: s}, nil
+	}
+
+	if strings.HasPrefix(s, "threshold=") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(s, "threshold="), "%"), 64)
+		if err != nil || v < 0 || v > 100 {
+			return FailPolicy{}, fmt.Errorf("Invalid threshold %q", s)
+		}
+		return FailPolicy{Mode: "threshold", Threshold: v}, nil
+	}
+
+	return FailPolicy{}, errors.New("Expected none, any, all or threshold=N%")
+}
+
+// ExitCode Return the process exit code for the summary under the policy.
+// Skipped nodes count as failures, and inventory errors fail the run under
+// every policy but none.
+func (s *Summary) ExitCode(p FailPolicy) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.InventoryErrors > 0 && p.Mode != "none" {
+		return 1
+	}
+
+	unsuccessful := s.Failed + s.Skipped
+	if s.Matched == 0 || unsuccessful == 0 {
+		return 0
+	}
+
+	switch p.Mode {
+	case "any":
+		return 1
+	case "all":
+		if s.Succeeded == 0 {
+			return 1
+		}
+	case "threshold":
+		if float64(unsuccessful)*100/float64(s.Matched) >= p.Threshold {
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseTOML Parse TOML inventory read from f writing found nodes to chan. Each
+// top level table is a node keyed by node name, unless it holds a single array
+// of tables, such as [[nodes]], listing the nodes.
+func ParseTOML(path string, f io.Reader, ch chan<- Node, l *log.Logger) {
+	defer close(ch)
+
+	fileBytes, err := ioutil.ReadAll(f)
+	if err != nil {
+		l.Printf("ERROR %v: %v\n", path, err)
+		return
+	}
+
+	root, order, err := parseTOML(string(fileBytes))
+	if err != nil {
+		l.Printf("ERROR %v: %v\n", path, err)
+		return
+	}
+
+	if len(order) == 1 {
+		if nodes, ok := root[order[0]].([]interface{}); ok {
+			sendDocument(path, nodes, nil, ch, l)
+			return
+		}
+	}
+
+	sendDocument(path, root, order, ch, l)
+}
+
+// tomlDateTime Bare values kept as strings, dates and times
+var tomlDateTime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}|^\d{2}:\d{2}`)
+
+// tomlParser Parser for TOML documents. Numbers are read as float64 as with
+// JSON inventories, and dates and times are kept as strings.
+type tomlParser struct {
+	s     string
+	i     int
+	root  map[string]interface{}
+	order []string // Top level keys in document order
+}
+
+// parseTOML Parse a TOML document, returning its root table and top level
+// keys in document order
+func parseTOML(s string) (map[string]interface{}, []string, error) {
+	p := &tomlParser{s: s, root: make(map[string]interface{})}
+	current := p.root
+
+	for p.skipBlank(); p.i < len(p.s); p.skipBlank() {
+		var err error
+
+		switch {
+		case strings.HasPrefix(p.s[p.i:], "[["):
+			p.i += 2
+			var keys []string
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("]]"); err == nil {
+					current, err = p.arrayTable(keys)
+				}
+			}
+		case p.s[p.i] == '[':
+			p.i++
+			var keys []string
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("]"); err == nil {
+					current, err = p.table(keys)
+				}
+			}
+		default:
+			var keys []string
+			var value interface{}
+			if keys, err = p.parseKey(); err == nil {
+				if err = p.expect("="); err == nil {
+					if value, err = p.parseValue(); err == nil {
+						err = p.set(current, keys, value)
+					}
+				}
+			}
+		}
+
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return p.root, p.order, nil
+}
+
+// errorf Return an error at the current line
+func (p *tomlParser) errorf(format string, a ...interface{}) error {
+	end := p.i
+	if end > len(p.s) {
+		end = len(p.s)
+	}
+	return fmt.Errorf("line %d: %v", strings.Count(p.s[:end], "\n")+1, fmt.Sprintf(format, a...))
+}
+
+// skipSpace Move past spaces and tabs
+func (p *tomlParser) skipSpace() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// skipBlank Move past whitespace, newlines and comments
+func (p *tomlParser) skipBlank() {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t', '\r', '\n':
+			p.i++
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+// skipComment Move to the end of a # comment
+func (p *tomlParser) skipComment() {
+	if end := strings.IndexByte(p.s[p.i:], '\n'); end >= 0 {
+		p.i += end
+	} else {
+		p.i = len(p.s)
+	}
+}
+
+// expect Move past token, or return an error
+func (p *tomlParser) expect(token string) error {
+	if p.skipSpace(); !strings.HasPrefix(p.s[p.i:], token) {
+		return p.errorf("Expected %q", token)
+	}
+	p.i += len(token)
+	return nil
+}
+
+// endOfLine Move past the end of a line and any comment, or return an error
+func (p *tomlParser) endOfLine() error {
+	if p.skipSpace(); p.i < len(p.s) && p.s[p.i] == '#' {
+		p.skipComment()
+	}
+
+	switch {
+	case p.i == len(p.s):
+	case p.s[p.i] == '\n':
+		p.i++
+	case strings.HasPrefix(p.s[p.i:], "\r\n"):
+		p.i += 2
+	default:
+		return p.errorf("Expected end of line, found %q", p.s[p.i])
+	}
+	return nil
+}
+
+// parseKey Parse a bare, quoted or dotted key
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+
+	for {
+		p.skipSpace()
+		if p.i == len(p.s) {
+			return nil, p.errorf("Expected key")
+		}
+
+		switch p.s[p.i] {
+		case '"', '\'':
+			key, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		default:
+			start := p.i
+			for p.i < len(p.s) && isTOMLBareKey(p.s[p.i]) {
+				p.i++
+			}
+			if p.i == start {
+				return nil, p.errorf("Invalid key character %q", p.s[p.i])
+			}
+			keys = append(keys, p.s[start:p.i])
+		}
+
+		if p.skipSpace(); p.i == len(p.s) || p.s[p.i] != '.' {
+			return keys, nil
+		}
+		p.i++
+	}
+}
+
+// isTOMLBareKey Return true if c may appear in a bare key
+func isTOMLBareKey(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// child Return the table under key in m, creating it when missing. For arrays
+// of tables the last table is returned.
+func (p *tomlParser) child(m map[string]interface{}, key string) (map[string]interface{}, error) {
+	switch v := m[key].(type) {
+	case nil:
+		t := make(map[string]interface{})
+		p.put(m, key, t)
+		return t, nil
+	case map[string]interface{}:
+		return v, nil
+	case []interface{}:
+		if len(v) > 0 {
+			if t, ok := v[len(v)-1].(map[string]interface{}); ok {
+				return t, nil
+			}
+		}
+	}
+
+	return nil, p.errorf("Key %q is not a table", key)
+}
+
+// put Store a value, recording the order of top level keys
+func (p *tomlParser) put(m map[string]interface{}, key string, v interface{}) {
+	if _, ok := m[key]; !ok && reflect.ValueOf(m).Pointer() == reflect.ValueOf(p.root).Pointer() {
+		p.order = append(p.order, key)
+	}
+	m[key] = v
+}
+
+// table Return the table for a [table] header
+func (p *tomlParser) table(keys []string) (map[string]interface{}, error) {
+	m := p.root
+	for _, key := range keys {
+		var err error
+		if m, err = p.child(m, key); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// arrayTable Append a table for an [[array]] header, returning it
+func (p *tomlParser) arrayTable(keys []string) (map[string]interface{}, error) {
+	m, err := p.table(keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	key := keys[len(keys)-1]
+	var tables []interface{}
+	switch v := m[key].(type) {
+	case nil:
+	case []interface{}:
+		tables = v
+	default:
+		return nil, p.errorf("Key %q is not an array of tables", key)
+	}
+
+	t := make(map[string]interface{})
+	p.put(m, key, append(tables, t))
+	return t, nil
+}
+
+// set Store a key = value pair in table m
+func (p *tomlParser) set(m map[string]interface{}, keys []string, value interface{}) error {
+	for _, key := range keys[:len(keys)-1] {
+		var err error
+		if m, err = p.child(m, key); err != nil {
+			return err
+		}
+	}
+
+	key := keys[len(keys)-1]
+	if _, dup := m[key]; dup {
+		return p.errorf("Duplicate key %q", key)
+	}
+	p.put(m, key, value)
+	return nil
+}
+
+// parseValue Parse a value
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.skipSpace(); p.i == len(p.s) {
+		return nil, p.errorf("Expected value")
+	}
+
+	switch p.s[p.i] {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		p.i++
+		items := make([]interface{}, 0)
+		for {
+			if p.skipBlank(); p.i == len(p.s) {
+				return nil, p.errorf("Unterminated array")
+			}
+			if p.s[p.i] == ']' {
+				p.i++
+				return items, nil
+			}
+
+			item, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+
+			if p.skipBlank(); p.i < len(p.s) && p.s[p.i] == ',' {
+				p.i++
+			} else if p.i == len(p.s) || p.s[p.i] != ']' {
+				return nil, p.errorf("Expected , or ] in array")
+			}
+		}
+	case '{':
+		p.i++
+		t := make(map[string]interface{})
+		for {
+			if p.skipSpace(); p.i < len(p.s) && p.s[p.i] == '}' {
+				p.i++
+				return t, nil
+			}
+
+			keys, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.set(t, keys, value); err != nil {
+				return nil, err
+			}
+
+			if p.skipSpace(); p.i < len(p.s) && p.s[p.i] == ',' {
+				p.i++
+			} else if p.i == len(p.s) || p.s[p.i] != '}' {
+				return nil, p.errorf("Expected , or } in inline table")
+			}
+		}
+	}
+
+	// Bare value, allowing the space between a date and time
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n,]}#", p.s[p.i]) < 0 {
+		p.i++
+	}
+	if p.i-start == 10 && p.i+3 < len(p.s) && p.s[p.i] == ' ' && tomlDateTime.MatchString(p.s[p.i+1:]) {
+		for p.i++; p.i < len(p.s) && strings.IndexByte(" \t\r\n,]}#", p.s[p.i]) < 0; p.i++ {
+		}
+	}
+	token := p.s[start:p.i]
+
+	switch {
+	case token == "true":
+		return true, nil
+	case token == "false":
+		return false, nil
+	case tomlDateTime.MatchString(token):
+		return token, nil
+	case strings.HasPrefix(strings.TrimLeft(token, "+-"), "0x"), strings.HasPrefix(strings.TrimLeft(token, "+-"), "0o"), strings.HasPrefix(strings.TrimLeft(token, "+-"), "0b"):
+		if i, err := strconv.ParseInt(token, 0, 64); err == nil {
+			return float64(i), nil
+		}
+	default:
+		if f, err := strconv.ParseFloat(strings.ReplaceAll(token, "_", ""), 64); err == nil {
+			return f, nil
+		}
+	}
+
+	return nil, p.errorf("Invalid value %q", token)
+}
+
+// parseString Parse a basic, literal or multi-line string
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.s[p.i : p.i+1]
+	multiline := strings.HasPrefix(p.s[p.i:], quote+quote+quote)
+	delimiter := quote
+	if multiline {
+		delimiter = quote + quote + quote
+	}
+	p.i += len(delimiter)
+
+	start := p.i
+	for ; p.i < len(p.s); p.i++ {
+		switch {
+		case p.s[p.i] == '\\' && quote == `"`:
+			if p.i+1 < len(p.s) { // Skip the escaped character, unless input ends
+				p.i++
+			}
+			continue
+		case p.s[p.i] == '\n' && !multiline:
+			return "", p.errorf("Unterminated string")
+		case !strings.HasPrefix(p.s[p.i:], delimiter):
+			continue
+		}
+
+		body := p.s[start:p.i]
+		p.i += len(delimiter)
+		if multiline { // A newline after the opening delimiter is trimmed
+			body = strings.TrimPrefix(strings.TrimPrefix(body, "\r"), "\n")
+		}
+		if quote == "'" {
+			return body, nil
+		}
+
+		s, err := unescapeTOML(body)
+		if err != nil {
+			return "", p.errorf("%v", err)
+		}
+		return s, nil
+	}
+
+	return "", p.errorf("Unterminated string")
+}
+
+// unescapeTOML Replace the escapes in a basic string
+func unescapeTOML(s string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i++; i == len(s) {
+			return "", errors.New("Invalid escape at end of string")
+		}
+
+		switch c := s[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'e':
+			b.WriteByte('\x1b')
+		case '"', '\\':
+			b.WriteByte(c)
+		case 'u', 'U':
+			size := 4
+			if c == 'U' {
+				size = 8
+			}
+			if i+size >= len(s) {
+				return "", fmt.Errorf("Invalid escape \\%c", c)
+			}
+			code, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("Invalid escape \\%c%s", c, s[i+1:i+1+size])
+			}
+			b.WriteRune(rune(code))
+			i += size
+		case ' ', '\t', '\r', '\n': // Line ending backslash, trimming following whitespace
+			end := i
+			for end < len(s) && strings.IndexByte(" \t\r\n", s[end]) >= 0 {
+				end++
+			}
+			if !strings.Contains(s[i:end], "\n") {
+				return "", errors.New("Invalid escape \\ followed by whitespace")
+			}
+			i = end - 1
+		default:
+			return "", fmt.Errorf("Invalid escape \\%c", c)
+		}
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tomlTests TOML documents with their parsed root table, or the start of
+// their error
+var tomlTests = []struct {
+	name  string
+	input string
+	want  map[string]interface{}
+	err   string
+}{
+	{"empty", "", map[string]interface{}{}, ""},
+	{"keyed nodes", "[web-01]\naddress = \"10.0.0.1\"\nport = 22\n\n[db-01]\nenabled = true\n",
+		map[string]interface{}{
+			"web-01": map[string]interface{}{"address": "10.0.0.1", "port": float64(22)},
+			"db-01":  map[string]interface{}{"enabled": true},
+		}, ""},
+	{"array of tables", "[[nodes]]\nnode = \"a\"\n[[nodes]]\nnode = \"b\"\n",
+		map[string]interface{}{"nodes": []interface{}{
+			map[string]interface{}{"node": "a"},
+			map[string]interface{}{"node": "b"},
+		}}, ""},
+	{"dotted and quoted keys", "[a]\nmeta.rack = \"r1\"\n\"odd key\" = 'x'\n",
+		map[string]interface{}{"a": map[string]interface{}{
+			"meta":    map[string]interface{}{"rack": "r1"},
+			"odd key": "x",
+		}}, ""},
+	{"arrays and inline tables", "[a]\ntags = [\"web\", \"prod\",]\nloc = { dc = \"ams\", rack = 3 }\n",
+		map[string]interface{}{"a": map[string]interface{}{
+			"tags": []interface{}{"web", "prod"},
+			"loc":  map[string]interface{}{"dc": "ams", "rack": float64(3)},
+		}}, ""},
+	{"strings", "[a]\nb = \"tab\\there \\u00e9\"\nc = 'C:\\path'\nd = \"\"\"\nline\"\"\"\n",
+		map[string]interface{}{"a": map[string]interface{}{"b": "tab\there é", "c": `C:\path`, "d": "line"}}, ""},
+	{"dates stay strings", "[a]\nborn = 2024-01-02T03:04:05Z\n",
+		map[string]interface{}{"a": map[string]interface{}{"born": "2024-01-02T03:04:05Z"}}, ""},
+	{"comments", "# top\n[a] # table\nb = 1 # value\n",
+		map[string]interface{}{"a": map[string]interface{}{"b": float64(1)}}, ""},
+	{"trailing backslash", "[a]\nb = \"\\", nil, "line 2: Unterminated string"},
+	{"unterminated string", "[a]\nb = \"x\nc = 1\n", nil, "line 2: Unterminated string"},
+	{"bad escape", "[a]\nb = \"\\q\"\n", nil, "line 2:"},
+	{"duplicate key", "[a]\nb = 1\nb = 2\n", nil, "line 3:"},
+	{"missing value", "[a]\nb =\n", nil, "line 2:"},
+	{"unclosed table", "[a\n", nil, "line 1:"},
+}
+
+// TestParseTOML Check documents parse to the expected tables or errors
+func TestParseTOML(t *testing.T) {
+	for _, test := range tomlTests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _, err := parseTOML(test.input)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("parseTOML(%q) error = %v, want %q", test.input, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTOML(%q): %v", test.input, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseTOML(%q) = %#v, want %#v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+// TestParseTOMLOrder Check top level keys are returned in document order
+func TestParseTOMLOrder(t *testing.T) {
+	_, order, err := parseTOML("[c]\n[a]\n[b]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c", "a", "b"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+// FuzzParseTOML Check no input panics
+func FuzzParseTOML(f *testing.F) {
+	for _, test := range tomlTests {
+		f.Add(test.input)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		parseTOML(s)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// TemplateData Data available to -template command arguments
+type TemplateData struct {
+	ID         string
+	Properties map[string]interface{}
+	Attempt    int
+}
+
+// TemplateFuncs Helpers available to -template command arguments, following
+// the names used by sprig where they overlap
+var TemplateFuncs = template.FuncMap{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       templateJoin,
+	"default":    templateDefault,
+	"quote":      func(v interface{}) string { return fmt.Sprintf("%q", fmt.Sprint(v)) },
+	"squote":     func(v interface{}) string { return "'" + fmt.Sprint(v) + "'" },
+	"shellquote": func(v interface{}) string { return QuotePOSIX(fmt.Sprint(v)) },
+	"cmdquote":   func(v interface{}) string { return QuoteCmd(fmt.Sprint(v)) },
+	"psquote":    func(v interface{}) string { return QuotePowerShell(fmt.Sprint(v)) },
+	"urlencode":  func(v interface{}) string { return url.QueryEscape(fmt.Sprint(v)) },
+	"toJson":     templateToJSON,
+	"get":        templateGet,
+}
+
+// templateJoin Join list items with sep
+func templateJoin(sep string, v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Sprint(v)
+	}
+
+	var items []string
+	for i := 0; i < rv.Len(); i++ {
+		items = append(items, fmt.Sprint(rv.Index(i).Interface()))
+	}
+	return strings.Join(items, sep)
+}
+
+// templateDefault Return v, or d when v is nil or empty
+func templateDefault(d interface{}, v interface{}) interface{} {
+	if v == nil {
+		return d
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return d
+		}
+	}
+
+	return v
+}
+
+// templateToJSON Encode v as JSON
+func templateToJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// templateGet Return the property at a dotted path, or nil when missing
+func templateGet(properties map[string]interface{}, path string) interface{} {
+	v, err := Node{Properties: properties}.GetProperty(&path)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// ParseTemplates Parse each command argument as a template, so errors are
+// reported before any node runs
+func ParseTemplates(c []string) ([]*template.Template, error) {
+	var templates []*template.Template
+
+	for index, arg := range c {
+		t, err := template.New(fmt.Sprintf("argument %d", index)).Option("missingkey=error").Funcs(TemplateFuncs).Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, nil
+}
+
+// ExecuteTemplates Render each argument template against the node
+func ExecuteTemplates(templates []*template.Template, data TemplateData) ([]string, error) {
+	var argv []string
+
+	for _, t := range templates {
+		var b bytes.Buffer
+		if err := t.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		argv = append(argv, b.String())
+	}
+
+	return argv, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Now Reference time for relative filter values, fixed with -now for
+// reproducible runs
+var Now = time.Now()
+
+// TimeLayouts Layouts tried, in order, when parsing times from filters and
+// properties. Layouts added with -time-layout are tried first.
+var TimeLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// PropertyTimeLayouts Layouts tried before TimeLayouts for specific properties
+var PropertyTimeLayouts = make(map[string][]string)
+
+// AddTimeLayout Register a time layout, either for all properties (LAYOUT) or
+// for a single property (KEY=LAYOUT)
+func AddTimeLayout(def string) {
+	if index := strings.Index(def, "="); index > 0 {
+		key := def[:index]
+		PropertyTimeLayouts[key] = append(PropertyTimeLayouts[key], def[index+1:])
+		return
+	}
+
+	TimeLayouts = append([]string{def}, TimeLayouts...)
+}
+
+var durationPart = regexp.MustCompile(`(\d+(?:\.\d+)?)(ns|us|µs|ms|s|m|h|d|w)`)
+
+// ParseDuration Parse a duration as time.ParseDuration does, additionally
+// accepting d (24h) and w (7d) units
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	body := strings.TrimLeft(s, "+-")
+
+	if body == "" || durationPart.ReplaceAllString(body, "") != "" {
+		return 0, fmt.Errorf("Invalid duration %q", s)
+	}
+
+	var d time.Duration
+	for _, part := range durationPart.FindAllStringSubmatch(body, -1) {
+		number, err := strconv.ParseFloat(part[1], 64)
+		if err != nil {
+			return 0, err
+		}
+
+		switch part[2] {
+		case "d":
+			d += time.Duration(number * float64(24*time.Hour))
+		case "w":
+			d += time.Duration(number * float64(7*24*time.Hour))
+		default:
+			unit, _ := time.ParseDuration("1" + part[2])
+			d += time.Duration(number * float64(unit))
+		}
+	}
+
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+// ParseRelativeTime Parse times relative to Now: now, now-7d, now+1h,
+// 7d ago and 1h from now
+func ParseRelativeTime(s string) (time.Time, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch {
+	case s == "now":
+		return Now, nil
+	case strings.HasPrefix(s, "now+") || strings.HasPrefix(s, "now-"):
+		d, err := ParseDuration(s[3:])
+		if err != nil {
+			return time.Time{}, err
+		}
+		return Now.Add(d), nil
+	case strings.HasSuffix(s, " ago"):
+		d, err := ParseDuration(strings.TrimSuffix(s, " ago"))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return Now.Add(-d), nil
+	case strings.HasSuffix(s, " from now"):
+		d, err := ParseDuration(strings.TrimSuffix(s, " from now"))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return Now.Add(d), nil
+	}
+
+	return time.Time{}, errors.New("Not a relative time")
+}
+
+// ParseTime Parse an absolute time using the given layouts followed by
+// TimeLayouts. Times without a zone are read as local time.
+func ParseTime(s string, layouts ...string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	candidates := append(append([]string{}, layouts...), TimeLayouts...)
+	for _, layout := range candidates {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Unrecognized time %q", s)
+}
+
+// ParseFilterTime Parse a filter value as either a relative or absolute time
+func ParseFilterTime(s string) (time.Time, error) {
+	if t, err := ParseRelativeTime(s); err == nil {
+		return t, nil
+	}
+
+	return ParseTime(s)
+}
+
+// ParsePropertyTime Parse a property value as a time, trying layouts
+// registered for the property first
+func ParsePropertyTime(key string, v interface{}) (time.Time, error) {
+	if t, ok := v.(time.Time); ok {
+		return t, nil
+	}
+
+	return ParseTime(fmt.Sprintf("%v", v), PropertyTimeLayouts[key]...)
+}
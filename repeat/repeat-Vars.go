@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// varLayer Properties read from a vars file
+type varLayer struct {
+	path       string
+	properties map[string]interface{}
+}
+
+// varGroup Group vars applying to nodes whose property key holds value
+type varGroup struct {
+	varLayer
+	key   string
+	value string
+}
+
+// VarLayers Properties layered onto inventory nodes from a vars directory, as
+// Ansible does: defaults for every node, group_vars selected by property
+// value, then the inventory's own properties, then host_vars by node name
+type VarLayers struct {
+	defaults *varLayer
+	groups   []varGroup
+	hosts    map[string]varLayer
+}
+
+// LoadVarLayers Read the vars directory dir, holding an optional defaults
+// file, group_vars/KEY=VALUE files and host_vars/NAME files. Group vars files
+// named without KEY= select by the groups property. Vars files are YAML, JSON
+// or TOML maps of properties.
+func LoadVarLayers(dir string) (*VarLayers, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &VarLayers{hosts: make(map[string]varLayer)}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		if file.IsDir() || name != "defaults" {
+			continue
+		}
+		if v.defaults != nil {
+			return nil, fmt.Errorf("%v: Only one defaults file may be given", filepath.Join(dir, file.Name()))
+		}
+
+		layer, err := readVarLayer(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		v.defaults = &layer
+	}
+
+	groups, err := readVarLayers(filepath.Join(dir, "group_vars"))
+	if err != nil {
+		return nil, err
+	}
+	for name, layer := range groups {
+		key, value, ok := strings.Cut(name, "=")
+		if !ok {
+			key, value = "groups", name
+		}
+		if key == "" {
+			return nil, fmt.Errorf("%v: Expected KEY=VALUE or a group name", layer.path)
+		}
+		v.groups = append(v.groups, varGroup{layer, key, value})
+	}
+	sort.Slice(v.groups, func(i, j int) bool { return v.groups[i].path < v.groups[j].path })
+
+	if v.hosts, err = readVarLayers(filepath.Join(dir, "host_vars")); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// readVarLayers Read every vars file in dir keyed by name without extension.
+// A missing directory holds none.
+func readVarLayers(dir string) (map[string]varLayer, error) {
+	layers := make(map[string]varLayer)
+
+	files, err := ioutil.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return layers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		if other, ok := layers[name]; ok {
+			return nil, fmt.Errorf("%v: Also given as %v", filepath.Join(dir, file.Name()), other.path)
+		}
+
+		layer, err := readVarLayer(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		layers[name] = layer
+	}
+
+	return layers, nil
+}
+
+// readVarLayer Read a YAML, JSON or TOML vars file holding a map of properties
+func readVarLayer(path string) (varLayer, error) {
+	layer := varLayer{path: path, properties: make(map[string]interface{})}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return layer, err
+	}
+
+	var doc interface{}
+	switch FormatByName(path) {
+	case "yaml":
+		doc, _, err = parseYAML(string(b))
+	case "json":
+		err = json.Unmarshal(b, &doc)
+	case "toml":
+		doc, _, err = parseTOML(string(b))
+	default:
+		return layer, fmt.Errorf("%v: Unsupported vars file type, expected YAML, JSON or TOML", path)
+	}
+	if err != nil {
+		return layer, fmt.Errorf("%v: %v", path, err)
+	}
+
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		layer.properties = doc
+	case nil: // Empty document
+	default:
+		return layer, fmt.Errorf("%v: Expected a map of properties", path)
+	}
+
+	return layer, nil
+}
+
+// matches Return true if the node's key property holds value, or is a list
+// with an item holding value
+func (g varGroup) matches(n Node) bool {
+	v, err := n.GetProperty(&g.key)
+	if err != nil {
+		return false
+	}
+
+	if items, ok := v.([]interface{}); ok {
+		for _, item := range items {
+			if formatValue(item) == g.value {
+				return true
+			}
+		}
+		return false
+	}
+
+	return formatValue(v) == g.value
+}
+
+// Apply Return the node with its properties layered: defaults, then matching
+// group vars in file name order, then the node's own properties, then its
+// host vars. Each layer replaces whole top level properties, except empty
+// inventory strings, and the origin of every property is recorded.
+func (v *VarLayers) Apply(n Node) Node {
+	properties := make(map[string]interface{}, len(n.Properties))
+	origins := make(map[string]string, len(n.Properties))
+	set := func(layer varLayer) {
+		for key, value := range layer.properties {
+			properties[key] = value
+			origins[key] = layer.path
+		}
+	}
+
+	if v.defaults != nil {
+		set(*v.defaults)
+	}
+
+	for _, group := range v.groups {
+		if group.matches(n) {
+			set(group.varLayer)
+		}
+	}
+
+	for key, value := range n.Properties {
+		if _, set := properties[key]; set && value == "" { // Blank CSV cells and the like
+			continue
+		}
+		properties[key] = value
+		origins[key] = n.Origin(key)
+	}
+
+	if name, err := n.GetProperty(&NameKey); err == nil {
+		if layer, ok := v.hosts[formatValue(name)]; ok {
+			set(layer)
+		}
+	}
+
+	n.Properties, n.Origins = properties, origins
+	return n
+}
+
+// WriteNodeProperties Write a node's properties as an aligned table of name,
+// value and the source each value came from
+func WriteNodeProperties(w io.Writer, n Node) error {
+	var keys []string
+	for key := range n.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROPERTY\tVALUE\tSOURCE")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, formatValue(n.Properties[key]), n.Origin(key))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ParseYAML Parse YAML inventory read from f writing found nodes to chan. It
+// holds a list of nodes, or a map of nodes keyed by node name.
+func ParseYAML(path string, f io.Reader, ch chan<- Node, l *log.Logger) {
+	defer close(ch)
+
+	fileBytes, err := ioutil.ReadAll(f)
+	if err != nil {
+		l.Printf("ERROR %v: %v\n", path, err)
+		return
+	}
+
+	doc, order, err := parseYAML(string(fileBytes))
+	if err != nil {
+		l.Printf("ERROR %v: %v\n", path, err)
+		return
+	}
+
+	sendDocument(path, doc, order, ch, l)
+}
+
+// yamlLine A line of a YAML document
+type yamlLine struct {
+	number int    // Line number from 1
+	indent int    // Leading spaces
+	text   string // Content after the indent with comments removed
+	raw    string // Line as read, for block scalars
+}
+
+// yamlParser Parser for the block style YAML subset used by inventories:
+// mappings, sequences, plain and quoted scalars, single line flow collections
+// and | and > block scalars. Anchors, aliases, tags and multiple documents are
+// not supported.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML Parse a YAML document, returning its value and, when it is a
+// mapping, its keys in document order
+func parseYAML(s string) (interface{}, []string, error) {
+	p := &yamlParser{}
+	for index, raw := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(raw, " ")
+		text := strings.TrimRight(stripYAMLComment(trimmed), " \t")
+		p.lines = append(p.lines, yamlLine{index + 1, len(raw) - len(trimmed), text, raw})
+	}
+
+	// Directives and document start
+	for p.skip(); p.pos < len(p.lines) && strings.HasPrefix(p.lines[p.pos].text, "%"); p.skip() {
+		p.pos++
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].text == "---" {
+		p.pos++
+	}
+
+	var doc interface{}
+	var order []string
+	var err error
+	if p.skip(); p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if _, _, ok := splitYAMLKey(line.text); ok && !isYAMLSequenceItem(line.text) {
+			doc, err = p.parseMapping(line.indent, &order)
+		} else {
+			doc, err = p.parseBlock(0)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Document end
+	if p.skip(); p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		switch line.text {
+		case "...":
+		case "---":
+			return nil, nil, p.errorf(line, "Multiple documents are not supported")
+		default:
+			return nil, nil, p.errorf(line, "Unexpected content %q", line.text)
+		}
+	}
+
+	return doc, order, nil
+}
+
+// errorf Return an error for a line
+func (p *yamlParser) errorf(line yamlLine, format string, a ...interface{}) error {
+	return fmt.Errorf("line %d: %v", line.number, fmt.Sprintf(format, a...))
+}
+
+// skip Move past blank and comment lines
+func (p *yamlParser) skip() {
+	for p.pos < len(p.lines) && p.lines[p.pos].text == "" {
+		p.pos++
+	}
+}
+
+// checkIndent Return an error if line is indented with tabs
+func (p *yamlParser) checkIndent(line yamlLine) error {
+	if strings.HasPrefix(line.text, "\t") {
+		return p.errorf(line, "Tabs are not allowed in indentation")
+	}
+	return nil
+}
+
+// parseBlock Parse the block starting at the current line, if indented at
+// least minIndent
+func (p *yamlParser) parseBlock(minIndent int) (interface{}, error) {
+	if p.skip(); p.pos >= len(p.lines) || p.lines[p.pos].indent < minIndent {
+		return nil, nil
+	}
+
+	line := p.lines[p.pos]
+	if err := p.checkIndent(line); err != nil {
+		return nil, err
+	}
+	if isYAMLSequenceItem(line.text) {
+		return p.parseSequence(line.indent)
+	}
+	if _, _, ok := splitYAMLKey(line.text); ok {
+		return p.parseMapping(line.indent, nil)
+	}
+
+	p.pos++
+	return p.parseScalar(line, line.text)
+}
+
+// parseSequence Parse the - items indented by indent
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	items := make([]interface{}, 0)
+
+	for p.skip(); p.pos < len(p.lines); p.skip() {
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && !isYAMLSequenceItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf(line, "Unexpected indentation")
+		}
+		if err := p.checkIndent(line); err != nil {
+			return nil, err
+		}
+
+		item, err := p.parseEntry(line, strings.TrimLeft(line.text[1:], " "), indent, false)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// parseMapping Parse the key: value entries indented by indent, appending
+// keys to order when not nil
+func (p *yamlParser) parseMapping(indent int, order *[]string) (interface{}, error) {
+	m := make(map[string]interface{})
+
+	for p.skip(); p.pos < len(p.lines); p.skip() {
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && isYAMLSequenceItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf(line, "Unexpected indentation")
+		}
+		if err := p.checkIndent(line); err != nil {
+			return nil, err
+		}
+
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, p.errorf(line, "Expected key: value, found %q", line.text)
+		}
+		if key == "<<" {
+			return nil, p.errorf(line, "Merge keys are not supported")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf(line, "Duplicate key %q", key)
+		}
+
+		value, err := p.parseEntry(line, rest, indent, true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+		if order != nil {
+			*order = append(*order, key)
+		}
+	}
+
+	return m, nil
+}
+
+// parseEntry Parse the value of a sequence item or mapping entry whose line
+// is the current one, given the text following - or key:
+func (p *yamlParser) parseEntry(line yamlLine, rest string, indent int, mapping bool) (interface{}, error) {
+	if rest == "" { // Value on the following lines
+		p.pos++
+		if p.skip(); p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent {
+				return p.parseBlock(next.indent)
+			}
+			if mapping && next.indent == indent && isYAMLSequenceItem(next.text) {
+				return p.parseSequence(indent)
+			}
+		}
+		return nil, nil
+	}
+
+	if !mapping { // Compact nested collection, - key: value or - - item
+		if _, _, ok := splitYAMLKey(rest); ok || isYAMLSequenceItem(rest) {
+			offset := line.indent + len(line.text) - len(rest)
+			p.lines[p.pos] = yamlLine{line.number, offset, rest, line.raw}
+			return p.parseBlock(offset)
+		}
+	}
+
+	p.pos++
+	if rest[0] == '|' || rest[0] == '>' {
+		return p.parseBlockScalar(line, rest, indent)
+	}
+	return p.parseScalar(line, rest)
+}
+
+// parseBlockScalar Parse the literal | or folded > block scalar following line
+func (p *yamlParser) parseBlockScalar(line yamlLine, header string, indent int) (interface{}, error) {
+	style, chomp, contentIndent := header[0], byte(0), 0
+	for _, c := range header[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			contentIndent = indent + int(c-'0')
+		default:
+			return nil, p.errorf(line, "Invalid block scalar header %q", header)
+		}
+	}
+
+	var lines []string
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos].raw
+		if strings.TrimSpace(raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+
+		lineIndent := len(raw) - len(strings.TrimLeft(raw, " "))
+		if contentIndent == 0 && lineIndent > indent {
+			contentIndent = lineIndent
+		}
+		if contentIndent == 0 || lineIndent < contentIndent {
+			break
+		}
+		lines = append(lines, raw[contentIndent:])
+	}
+
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var b strings.Builder
+	folded := false
+	for index, s := range lines {
+		switch {
+		case style == '|':
+			if index > 0 {
+				b.WriteByte('\n')
+			}
+		case s == "":
+			b.WriteByte('\n')
+			folded = false
+			continue
+		case folded:
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+		folded = true
+	}
+
+	switch {
+	case chomp == '+':
+		b.WriteString(strings.Repeat("\n", trailing+1))
+	case chomp == 0 && len(lines) > 0:
+		b.WriteByte('\n')
+	}
+
+	return b.String(), nil
+}
+
+// parseScalar Parse a single line value
+func (p *yamlParser) parseScalar(line yamlLine, text string) (interface{}, error) {
+	switch text[0] {
+	case '[', '{', '"', '\'':
+		v, rest, err := parseYAMLFlow(text)
+		if err != nil {
+			return nil, p.errorf(line, "%v", err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, p.errorf(line, "Unexpected %q after value", strings.TrimSpace(rest))
+		}
+		return v, nil
+	case '&', '*', '!':
+		return nil, p.errorf(line, "Anchors, aliases and tags are not supported")
+	}
+
+	return yamlPlainScalar(text), nil
+}
+
+// isYAMLSequenceItem Return true if text starts a sequence item
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey Split key: value text into its key and the value text
+func splitYAMLKey(text string) (string, string, bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+
+	key, rest := "", ""
+	if text[0] == '"' || text[0] == '\'' {
+		v, after, err := parseYAMLQuoted(text)
+		if err != nil {
+			return "", "", false
+		}
+		key, rest = v, strings.TrimLeft(after, " ")
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else {
+		index := strings.Index(text+" ", ": ")
+		if index < 0 {
+			return "", "", false
+		}
+		key, rest = strings.TrimSpace(text[:index]), text[index+1:]
+	}
+
+	if key == "" || (rest != "" && rest[0] != ' ') {
+		return "", "", false
+	}
+
+	return key, strings.TrimSpace(rest), true
+}
+
+// stripYAMLComment Remove a trailing # comment outside quotes
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[{,:", s[i-1]) >= 0):
+			quote = c
+		}
+	}
+
+	return s
+}
+
+// yamlPlainScalar Return the value of an unquoted scalar
+func yamlPlainScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+
+	if plainNumber.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+
+	return s
+}
+
+// parseYAMLQuoted Parse the single or double quoted string s starts with,
+// returning it and the remaining text
+func parseYAMLQuoted(s string) (string, string, error) {
+	if s[0] == '\'' {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' { // '' is a literal '
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), s[i+1:], nil
+		}
+		return "", "", errors.New("Unterminated quoted string")
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("Invalid quoted string %s", s[:i+1])
+			}
+			return v, s[i+1:], nil
+		}
+	}
+	return "", "", errors.New("Unterminated quoted string")
+}
+
+// parseYAMLFlow Parse the flow collection or scalar s starts with, returning
+// it and the remaining text
+func parseYAMLFlow(s string) (interface{}, string, error) {
+	s = strings.TrimLeft(s, " ")
+	if s == "" {
+		return nil, "", nil
+	}
+
+	switch s[0] {
+	case '[':
+		items := make([]interface{}, 0)
+		for s = strings.TrimLeft(s[1:], " "); ; {
+			if s == "" {
+				return nil, "", errors.New("Unterminated flow sequence")
+			}
+			if s[0] == ']' {
+				return items, s[1:], nil
+			}
+
+			item, rest, err := parseYAMLFlow(s)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, item)
+
+			if s = strings.TrimLeft(rest, " "); strings.HasPrefix(s, ",") {
+				s = strings.TrimLeft(s[1:], " ")
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, "", errors.New("Expected , or ] in flow sequence")
+			}
+		}
+	case '{':
+		m := make(map[string]interface{})
+		for s = strings.TrimLeft(s[1:], " "); ; {
+			if s == "" {
+				return nil, "", errors.New("Unterminated flow mapping")
+			}
+			if s[0] == '}' {
+				return m, s[1:], nil
+			}
+
+			key, rest, err := parseYAMLFlow(s)
+			if err != nil {
+				return nil, "", err
+			}
+
+			var value interface{}
+			if s = strings.TrimLeft(rest, " "); strings.HasPrefix(s, ":") {
+				if value, rest, err = parseYAMLFlow(s[1:]); err != nil {
+					return nil, "", err
+				}
+				s = strings.TrimLeft(rest, " ")
+			}
+			m[fmt.Sprint(key)] = value
+
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimLeft(s[1:], " ")
+			} else if !strings.HasPrefix(s, "}") {
+				return nil, "", errors.New("Expected , or } in flow mapping")
+			}
+		}
+	case '"', '\'':
+		return parseYAMLQuoted(s)
+	}
+
+	// Plain scalar, ending at a flow indicator or a : separator
+	end := 0
+	for ; end < len(s); end++ {
+		if strings.IndexByte(",[]{}", s[end]) >= 0 {
+			break
+		}
+		if s[end] == ':' && (end+1 == len(s) || strings.IndexByte(" ,]}", s[end+1]) >= 0) {
+			break
+		}
+	}
+	return yamlPlainScalar(strings.TrimSpace(s[:end])), s[end:], nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// yamlTests YAML documents with their parsed value, or the start of their
+// error
+var yamlTests = []struct {
+	name  string
+	input string
+	want  interface{}
+	err   string
+}{
+	{"empty", "", nil, ""},
+	{"list of nodes", "- node: a\n  port: 22\n- node: b\n",
+		[]interface{}{
+			map[string]interface{}{"node": "a", "port": float64(22)},
+			map[string]interface{}{"node": "b"},
+		}, ""},
+	{"keyed nodes", "---\nweb-01:\n  address: 10.0.0.1\n  tags: [web, prod]\ndb-01:\n",
+		map[string]interface{}{
+			"web-01": map[string]interface{}{"address": "10.0.0.1", "tags": []interface{}{"web", "prod"}},
+			"db-01":  nil,
+		}, ""},
+	{"scalars", "a: true\nb: ~\nc: 007\nd: 1.5\ne: 'it''s'\nf: \"tab\\t\"\ng: x # comment\n",
+		map[string]interface{}{"a": true, "b": nil, "c": "007", "d": 1.5, "e": "it's", "f": "tab\t", "g": "x"}, ""},
+	{"nested", "a:\n  b:\n    - 1\n    - c: d\n",
+		map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{
+			float64(1), map[string]interface{}{"c": "d"},
+		}}}, ""},
+	{"flow map", "a: {b: 1, c: [x, y]}\n",
+		map[string]interface{}{"a": map[string]interface{}{"b": float64(1), "c": []interface{}{"x", "y"}}}, ""},
+	{"block scalars", "a: |\n  one\n  two\nb: >\n  one\n  two\n",
+		map[string]interface{}{"a": "one\ntwo\n", "b": "one two\n"}, ""},
+	{"tab indent", "a:\n\tb: 1\n", nil, "line 2:"},
+	{"bad indent", "a:\n    b: 1\n  c: 2\n", nil, "line 3:"},
+	{"unterminated quote", "a: \"x\n", nil, "line 1:"},
+	{"unterminated flow", "a: [x, y\n", nil, "line 1:"},
+}
+
+// TestParseYAML Check documents parse to the expected values or errors
+func TestParseYAML(t *testing.T) {
+	for _, test := range yamlTests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _, err := parseYAML(test.input)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("parseYAML(%q) error = %v, want %q", test.input, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseYAML(%q): %v", test.input, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseYAML(%q) = %#v, want %#v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+// TestParseYAMLOrder Check mapping keys are returned in document order
+func TestParseYAMLOrder(t *testing.T) {
+	_, order, err := parseYAML("c: 1\na: 2\nb: 3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c", "a", "b"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+// FuzzParseYAML Check no input panics
+func FuzzParseYAML(f *testing.F) {
+	for _, test := range yamlTests {
+		f.Add(test.input)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		parseYAML(s)
+	})
+}
@@ -1,151 +1,708 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-// BuildEnv Build an environment definition array for use with exec.Command
-func BuildEnv(properties map[string](interface{})) []string {
-	e := os.Environ()
+// listFlag Command line flag which may be given multiple times
+type listFlag []string
+
+// String Return flag values as a comma-separated list
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set Append a flag value
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// EnvDelta Build the environment definitions added for node properties,
+// sorted by name. Nested properties are added under their own names.
+func EnvDelta(properties map[string](interface{})) []string {
+	var e []string
 	for k, v := range properties {
-		_, ok := v.(map[string](interface{}))
+		child, ok := v.(map[string](interface{}))
 		if !ok {
 			e = append(e, fmt.Sprintf("%s=%v", k, v))
 			continue
 		}
 
-		child := BuildEnv(v.(map[string](interface{})))
-		for _, v := range child {
-			e = append(e, v)
-		}
+		e = append(e, EnvDelta(child)...)
 	}
+
+	sort.Strings(e)
 	return e
 }
 
-// ParseArguments Parse program command and filter arguments
-func ParseArguments(args []string) ([]string, []Filter) {
+// BuildEnv Build an environment definition array for use with exec.Command
+func BuildEnv(properties map[string](interface{})) []string {
+	return append(os.Environ(), EnvDelta(properties)...)
+}
+
+// ParseArguments Parse program command and filter expression arguments
+func ParseArguments(args []string) ([]string, Expression, error) {
 	var command []string
-	var filters []Filter
+	filterDefs := args
 
-	for index, filterDef := range args {
-		if filterDef == "-" { // Start of command flag
+	for index, arg := range args {
+		if arg == "-" { // Start of command flag
+			filterDefs = args[:index]
 			command = args[index+1:]
 			break
 		}
+	}
 
-		if len(filterDef) < 4 { // Not a filter C==V
-			continue
+	filter, err := ParseExpression(filterDefs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return command, filter, nil
+}
+
+// ScheduleNodes Walk path to file, or read stdin for -, an http(s) URL or the
+// output of an inventory script, and pass nodes matching filter to submit.
+// Files are read in the given format, or with auto by their name, and other
+// sources by their content. Only an inventory script named by path is run,
+// not those found in a directory.
+func ScheduleNodes(path string, format string, filter Expression, submit func(Node), l *log.Logger) {
+	scheduleNodes(path, format, filter, submit, l, true)
+}
+
+// scheduleNodes Read the inventory at path as for ScheduleNodes, running it
+// as an inventory script only when named directly
+func scheduleNodes(path string, format string, filter Expression, submit func(Node), l *log.Logger, named bool) {
+	var f io.Reader = os.Stdin
+	var data []byte // Inventory from a URL or script
+	formatHint := ""
+
+	switch {
+	case path == "-":
+		path = "stdin"
+	case IsURL(path):
+		body, contentType, err := FetchInventory(path, l)
+		if err != nil {
+			l.Printf("ERROR %v: %v\n", redactURL(path), err)
+			return
+		}
+		path, data = redactURL(path), body
+		if formatHint = FormatByContentType(contentType); formatHint == "" {
+			if u, err := url.Parse(path); err == nil {
+				if formatHint = FormatByName(u.Path); formatHint == "hosts" && isHostsName(u.Path) {
+					formatHint = HostsOrINI(body)
+				}
+			}
 		}
+	default:
+		/* Endure path is valid */
 
-		filter, err := NewFilter(filterDef)
-		if err != nil { // Not a filter
-			continue
+		stat, err := os.Stat(path)
+		if err != nil {
+			l.Printf("ERROR %v: %v\n", path, err)
+			return
 		}
 
-		filters = append(filters, filter)
-	}
+		// If path is a directory call function for each entry
+		if stat.IsDir() {
+			files, err := ioutil.ReadDir(path)
+			if err != nil {
+				l.Printf("ERROR %v: %v\n", path, err)
+				return
+			}
 
-	return command, filters
-}
+			for _, file := range files {
+				scheduleNodes(filepath.Join(path, file.Name()), format, filter, submit, l, false)
+			}
 
-// ScheduleNodes Walk path to file and schedule repeats for nodes
-func ScheduleNodes(path string, filters *[]Filter, command *[]string, async bool, l *log.Logger, wg *sync.WaitGroup) {
-	/* Endure path is valid */
+			return
+		}
 
-	stat, err := os.Stat(path)
-	if err != nil {
-		l.Printf("ERROR %v: %v\n", path, err)
-		return
-	}
+		// Run inventory scripts, other than data files with the executable bit.
+		// Stray files in directories on mounts where every file is executable
+		// are not run.
+		if isExecutable(stat) && FormatByName(path) == "" {
+			if !named {
+				l.Printf("ERROR %v: Inventory scripts are only run when given as -inventory\n", path)
+				return
+			}
+			if data, err = RunInventory(path); err != nil {
+				l.Printf("ERROR %v: %v\n", path, err)
+				return
+			}
+			break
+		}
 
-	// If path is a directory call function for each entry
-	if stat.IsDir() {
-		files, err := ioutil.ReadDir(path)
+		file, err := os.Open(path)
 		if err != nil {
 			l.Printf("ERROR %v: %v\n", path, err)
 			return
 		}
+		defer file.Close()
+		f = file
+	}
 
-		for _, file := range files {
-			ScheduleNodes(filepath.Join(path, file.Name()), filters, command, async, l, wg)
+	// Pick the inventory format
+	if data != nil {
+		f = bytes.NewReader(data)
+	}
+	r := bufio.NewReader(f)
+	if format == "auto" {
+		switch {
+		case data != nil:
+			if format = formatHint; format == "" {
+				format = SniffFormat(data)
+			}
+			if format == "json" && JSONRoot == nil && IsAnsibleInventory(data) {
+				format = "ansible"
+			}
+		case f == os.Stdin:
+			format = SniffFormat(PeekHead(r))
+		default:
+			if format = FormatByName(path); format == "hosts" && isHostsName(path) {
+				head, _ := r.Peek(sniffSize)
+				format = HostsOrINI(head)
+			}
 		}
+	}
 
+	parse, ok := Parsers[format]
+	if !ok {
+		l.Printf("ERROR %v: Unknown or unsupported file type\n", path)
 		return
 	}
 
 	// Create channel to collect nodes
 	ch := make(chan Node)
 
-	// Parse inventory files
-	if strings.EqualFold(path[len(path)-4:], ".csv") {
-		go ParseCSV(path, ch, l)
-	} else if strings.EqualFold(path[len(path)-5:], ".json") {
-		go ParseJSON(path, ch, l)
-	} else {
-		l.Printf("ERROR %v: Unknown or unsupported file type\n", path)
-		return
-	}
+	// Parse inventory
+	go parse(path, r, ch, l)
 
 	// Read Nodes from channel and process
 	for n := range ch {
-		if n.Filter(filters) {
-			if async {
-				wg.Add(1)
-				go n.Process(command, true, l, wg)
-			} else {
-				n.Process(command, false, l, wg)
-			}
+		n.Source = path
+		if n.Filter(filter) {
+			submit(n)
 		}
 	}
 }
 
+// inventoryExitCode Return the exit code for a run which only read the
+// inventory, 1 when errors were logged reading it
+func inventoryExitCode(errors int, l *log.Logger) int {
+	if errors > 0 {
+		l.Printf("ERROR %d inventory errors\n", errors)
+		return 1
+	}
+
+	return 0
+}
+
 // main Entrypoint
 func main() {
 	/* Parse arguments - flags */
 
 	async := flag.Bool("async", false, "Process selected nodes asynchronously")
-	inventory := flag.String("inventory", "inventory/", "Inventory location")
+	parallel := flag.Int("parallel", 0, "Maximum nodes processed concurrently, implies -async above 1 (default with -async number of CPUs)")
+	var groupLimitDefs listFlag
+	flag.Var(&groupLimitDefs, "group-limit", "Maximum nodes processed concurrently per value of a property, KEY=N (repeatable)")
+	batch := flag.String("batch", "", "Process nodes in rolling batches of these comma-separated sizes, counts or percentages such as 1,10%,100%")
+	batchPause := flag.Duration("batch-pause", 0, "Pause between batches")
+	batchCheck := flag.String("batch-check", "", "Health check command run with the system shell between batches, stopping the run on failure")
+	timeout := flag.Duration("timeout", 0, "Maximum run time per node, 0 for none")
+	deadline := flag.Duration("deadline", 0, "Maximum run time for all nodes, 0 for none")
+	grace := flag.Duration("grace", 5*time.Second, "Time between forwarding SIGINT/SIGTERM to commands and killing them")
 
-	bash := flag.Bool("bash", false, "Enable bash helper")
-	cmd := flag.Bool("cmd", false, "Enable cmd.exe helper")
-	ps := flag.Bool("ps", false, "Enable powershell.exe helper")
-	pwsh := flag.Bool("pwsh", false, "Enable pwsh.exe helper")
+	retries := flag.Int("retries", 0, "Additional attempts for failed nodes")
+	retryDelay := flag.Duration("retry-delay", time.Second, "Delay before the first retry, doubled for each further retry")
+	retryMaxDelay := flag.Duration("retry-max-delay", time.Minute, "Maximum delay between retries")
+	retryOnExit := flag.String("retry-on-exit", "", "Retry only on these comma-separated exit codes, -1 for timeouts")
+	retryOnStderr := flag.String("retry-on-stderr", "", "Retry only when stderr matches this regular expression")
+	inventory := flag.String("inventory", "inventory/", "Inventory location, - for stdin, an http(s) URL or an inventory script")
+	format := flag.String("format", "auto", "Inventory format, auto, csv, json, ndjson, yaml, toml, ini, hosts or ansible")
+	var inventoryHeaders listFlag
+	flag.Var(&inventoryHeaders, "inventory-header", "HTTP inventory request header, Name: value with $VARIABLE references expanded (repeatable)")
+	flag.DurationVar(&Dynamic.Cache, "inventory-cache", 0, "Reuse fetched HTTP inventories for this long, 0 for no cache")
+	flag.DurationVar(&Dynamic.Timeout, "inventory-timeout", Dynamic.Timeout, "Maximum time to run an inventory script or fetch an HTTP inventory")
+	csvDelimiter := flag.String("csv-delimiter", ",", "CSV inventory field delimiter, a single character or tab")
+	csvComment := flag.String("csv-comment", "", "Ignore CSV inventory lines starting with this character, such as #")
+	flag.BoolVar(&CSVDialect.NoHeader, "csv-no-header", false, "CSV inventories have no header row, columns are named col1, col2, ...")
+	flag.BoolVar(&CSVDialect.LazyQuotes, "csv-lazy-quotes", false, "Allow stray quotes in CSV inventory fields")
+	csvSchema := flag.String("csv-schema", "", "CSV inventory column types, comma-separated NAME:TYPE with TYPE string, int, float, bool or list[:SEPARATOR]")
+	mergeKey := flag.String("merge-key", "", "Merge records from all inventory sources sharing a value of this property into single nodes")
+	mergeConflict := flag.String("merge-conflict", "error", "Resolve properties with different values when merging: first, last or error")
+	list := flag.Bool("list", false, "Print matched nodes as a table and exit without running anything")
+	count := flag.Bool("count", false, "Print the number of matched nodes and exit without running anything")
+	distinct := flag.String("distinct", "", "Print each value of this property with the number of matched nodes holding it and exit without running anything")
+	export := flag.String("export", "", "Write matched nodes as csv, json or ndjson and exit without running anything")
+	columns := flag.String("columns", "", "Comma-separated properties for -list and -export (default all)")
+	vars := flag.String("vars", "", "Directory of defaults, group_vars/KEY=VALUE and host_vars/NAME files layered onto inventory nodes")
+	showNode := flag.String("show-node", "", "Print the properties of this node, after merging and -vars layering, with where each came from, and exit")
+	jsonRoot := flag.String("json-root", "", "Path to the nodes within JSON inventories, such as .data.hosts")
+	flag.StringVar(&NameKey, "name-key", NameKey, "Property holding the node name for YAML and TOML inventories keyed by name, Ansible and hosts file inventories")
+
+	shell := flag.String("shell", "", "Run the command as a script with this shell profile, such as bash, bash-strict, sh, zsh, cmd, ps, pwsh or python")
+	shellConfig := flag.String("shell-config", DefaultShellConfig(), "JSON file of additional shell profiles")
+	bash := flag.Bool("bash", false, "Alias for -shell bash")
+	cmd := flag.Bool("cmd", false, "Alias for -shell cmd")
+	ps := flag.Bool("ps", false, "Alias for -shell ps")
+	pwsh := flag.Bool("pwsh", false, "Alias for -shell pwsh")
+	rawSubstitution := flag.Bool("raw", false, "Do not quote values substituted into shell scripts")
+
+	useTemplate := flag.Bool("template", false, "Render command arguments as Go text/template templates instead of ${} substitution")
+	dryRun := flag.Bool("dry-run", false, "Print the command and environment rendered for each node without running anything")
+	confirm := flag.Bool("confirm", false, "Print the plan as with -dry-run and ask for confirmation before running")
+	output := flag.String("output", "text", "Output format, text or jsonl")
+	failOn := flag.String("fail-on", "any", "Exit non-zero when nodes fail or are skipped: none, any, all or threshold=N%")
+	maxFailures := flag.Int("max-failures", 0, "Stop starting nodes after this many failures, 0 for no limit")
+	maxFailurePercent := flag.Float64("max-failure-percent", 0, "Stop starting nodes once this percent of all matched nodes failed, 0 for no limit")
+	abortRunning := flag.Bool("abort-running", false, "Kill running commands when -max-failures or -max-failure-percent is reached")
+	summaryKeys := flag.String("summary-keys", "node,address", "Comma-separated properties identifying failed nodes in the summary")
+
+	now := flag.String("now", "", "Reference time for relative time filters (default current time)")
+	var timeLayouts listFlag
+	flag.Var(&timeLayouts, "time-layout", "Additional time layout, LAYOUT or KEY=LAYOUT (repeatable)")
 
 	flag.Parse()
+	JSONRoot = ParseJSONRoot(*jsonRoot)
+	Dynamic.Headers = inventoryHeaders
+
+	/* Select output. Node log lines are replaced by JSON records on stdout
+	with -output jsonl, and other log lines move to stderr */
+
+	l := log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+	nl := l
+	var w *ResultWriter
+
+	switch *output {
+	case "text":
+	case "jsonl":
+		l = log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+		nl = log.New(io.Discard, "", 0)
+		w = NewResultWriter(os.Stdout)
+	default:
+		l.Fatalf("ERROR -output: Unknown format %q\n", *output)
+	}
+
+	/* Configure inventory parsing */
+
+	if _, ok := Parsers[*format]; !ok && *format != "auto" {
+		l.Fatalf("ERROR -format: Unknown inventory format %q\n", *format)
+	}
+
+	if !MergePolicies[*mergeConflict] {
+		l.Fatalf("ERROR -merge-conflict: Unknown policy %q\n", *mergeConflict)
+	}
+
+	var err error
+	if CSVDialect.Delimiter, err = ParseCSVDelimiter(*csvDelimiter); err != nil {
+		l.Fatalf("ERROR -csv-delimiter: %v\n", err)
+	}
+	if *csvComment != "" {
+		if CSVDialect.Comment, err = ParseCSVDelimiter(*csvComment); err != nil || CSVDialect.Comment == CSVDialect.Delimiter {
+			l.Fatalf("ERROR -csv-comment: Invalid comment character %q\n", *csvComment)
+		}
+	}
+	if CSVDialect.Schema, err = ParseCSVSchema(*csvSchema); err != nil {
+		l.Fatalf("ERROR -csv-schema: %v\n", err)
+	}
+
+	/* Configure time parsing ahead of filters, which resolve relative times */
+
+	for _, layout := range timeLayouts {
+		AddTimeLayout(layout)
+	}
+
+	if *now != "" {
+		t, err := ParseTime(*now)
+		if err != nil {
+			l.Fatalf("ERROR -now: %v\n", err)
+		}
+		Now = t
+	}
+
+	/* Select an inventory query, run instead of the command */
+
+	var query Query
+	queries := []struct {
+		name    string
+		enabled bool
+	}{{"list", *list}, {"count", *count}, {"distinct", *distinct != ""}, {"export", *export != ""}}
+	for _, q := range queries {
+		if !q.enabled {
+			continue
+		}
+		if query.Mode != "" {
+			l.Fatalf("ERROR -%v: Only one of -list, -count, -distinct and -export may be given\n", q.name)
+		}
+		query.Mode = q.name
+	}
+	query.Key, query.Format = *distinct, *export
+
+	if *export != "" && !ExportFormats[*export] {
+		l.Fatalf("ERROR -export: Unknown format %q\n", *export)
+	}
+	if *columns != "" {
+		if query.Mode != "list" && query.Mode != "export" {
+			l.Fatalf("ERROR -columns: Only applies to -list and -export\n")
+		}
+		for _, column := range strings.Split(*columns, ",") {
+			query.Columns = append(query.Columns, strings.TrimSpace(column))
+		}
+	}
 
 	/* Parse arguments - filters and command */
 
-	var filters []Filter
-	var command []string
-	command, filters = ParseArguments(flag.Args())
+	command, filter, err := ParseArguments(flag.Args())
+	if err != nil {
+		l.Fatalf("ERROR %v\n", err)
+	}
 
-	/* Add command / process invocation helpers */
+	/* Run the command as a script with the selected shell profile. Values
+	substituted into the script are quoted for that shell */
 
-	if *bash {
-		command = append([]string{"bash", "-c"}, command...)
+	shellName := *shell
+	aliases := []struct {
+		name    string
+		enabled bool
+	}{{"bash", *bash}, {"cmd", *cmd}, {"ps", *ps}, {"pwsh", *pwsh}}
+	for _, alias := range aliases {
+		if !alias.enabled {
+			continue
+		}
+		if shellName != "" {
+			l.Fatalf("ERROR -%v: Only one of -shell, -bash, -cmd, -ps and -pwsh may be given\n", alias.name)
+		}
+		shellName = alias.name
 	}
-	if *cmd {
-		command = append([]string{"cmd.exe", "/C"}, command...)
+
+	var quote *Quoter
+	quoteFrom, stdinScript := 0, false
+	if shellName != "" {
+		shellConfigSet := false
+		flag.Visit(func(f *flag.Flag) { shellConfigSet = shellConfigSet || f.Name == "shell-config" })
+
+		profiles, err := LoadShellProfiles(*shellConfig, shellConfigSet)
+		if err != nil {
+			l.Fatalf("ERROR -shell-config: %v\n", err)
+		}
+
+		profile, ok := profiles[shellName]
+		if !ok {
+			l.Fatalf("ERROR -shell: Unknown shell %q, expected one of %v\n", shellName, strings.Join(ShellNames(profiles), ", "))
+		}
+
+		command = append(append([]string{}, profile.Argv...), command...)
+		quoteFrom, stdinScript = len(profile.Argv), profile.Delivery == "stdin"
+		if !*rawSubstitution {
+			if quoter, ok := Quoters[profile.Quote]; ok {
+				quote = &quoter
+			}
+		}
+
+		// Template output cannot be told apart from the script around it, so
+		// it is not quoted
+		if *useTemplate && !*rawSubstitution {
+			l.Fatalf("ERROR -template: Values are not quoted for -shell, give -raw and quote them with shellquote, cmdquote or psquote\n")
+		}
 	}
-	if *ps {
-		command = append([]string{"powershell.exe", "-Command"}, command...)
+
+	policy, err := ParseFailPolicy(*failOn)
+	if err != nil {
+		l.Fatalf("ERROR -fail-on: %v\n", err)
 	}
-	if *pwsh {
-		command = append([]string{"pwsh.exe", "-Command"}, command...)
+
+	/* Read inventory, merging records from every source with -merge-key and
+	layering -vars, before filtering */
+
+	var layers *VarLayers
+	if *vars != "" {
+		if layers, err = LoadVarLayers(*vars); err != nil {
+			l.Fatalf("ERROR -vars: %v\n", err)
+		}
+	}
+
+	inventoryErrors := NewErrorCounter(l) // Unreadable sources, files and rows fail the run
+	il := log.New(inventoryErrors, "", 0)
+	schedule := func(filter Expression, submit func(Node)) {
+		accept := func(n Node) {
+			if layers != nil {
+				n = layers.Apply(n)
+			}
+			if n.Filter(filter) {
+				submit(n)
+			}
+		}
+
+		if *mergeKey == "" {
+			ScheduleNodes(*inventory, *format, nil, accept, il)
+			return
+		}
+
+		var records []Node
+		ScheduleNodes(*inventory, *format, nil, func(n Node) { records = append(records, n) }, il)
+
+		nodes, err := MergeNodes(records, *mergeKey, *mergeConflict, il)
+		if err != nil {
+			l.Fatalf("ERROR -merge-key: %v\n", err)
+		}
+		for _, n := range nodes {
+			accept(n)
+		}
+	}
+
+	if *showNode != "" { // Whether or not it matches the filter
+		found := false
+		schedule(nil, func(n Node) {
+			if name, err := n.GetProperty(&NameKey); err != nil || formatValue(name) != *showNode {
+				return
+			}
+			if found {
+				fmt.Println()
+			}
+			found = true
+			if err := WriteNodeProperties(os.Stdout, n); err != nil {
+				l.Fatalf("ERROR %v\n", err)
+			}
+		})
+
+		if !found {
+			l.Fatalf("ERROR -show-node: Node %q not found\n", *showNode)
+		}
+		os.Exit(inventoryExitCode(inventoryErrors.Count(), l))
+	}
+
+	if query.Mode != "" {
+		var nodes []Node
+		schedule(filter, func(n Node) { nodes = append(nodes, n) })
+
+		if err := query.Run(os.Stdout, nodes); err != nil {
+			l.Fatalf("ERROR %v\n", err)
+		}
+		os.Exit(inventoryExitCode(inventoryErrors.Count(), l))
 	}
 
 	/* Schedule nodes for repeat executions of command */
 
-	l := log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds)
-	var wg sync.WaitGroup
-	ScheduleNodes(*inventory, &filters, &command, *async, l, &wg)
+	if *parallel < 1 {
+		*parallel = 1
+		if *async {
+			*parallel = runtime.NumCPU()
+		}
+	}
+
+	groupLimits := make(map[string]int)
+	for _, def := range groupLimitDefs {
+		index := strings.LastIndex(def, "=")
+		limit, err := strconv.Atoi(def[index+1:])
+		if index < 1 || err != nil || limit < 1 {
+			l.Fatalf("ERROR -group-limit: Invalid limit %q\n", def)
+		}
+		groupLimits[def[:index]] = limit
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+
+	var summary Summary
+	o := ProcessOptions{
+		Quote:         quote,
+		QuoteFrom:     quoteFrom,
+		Stdin:         stdinScript,
+		Timeout:       *timeout,
+		WaitDelay:     *grace,
+		Retries:       *retries,
+		RetryDelay:    *retryDelay,
+		RetryMaxDelay: *retryMaxDelay,
+	}
+
+	if *retryOnExit != "" {
+		for _, code := range strings.Split(*retryOnExit, ",") {
+			c, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil {
+				l.Fatalf("ERROR -retry-on-exit: %v\n", err)
+			}
+			o.RetryExitCodes = append(o.RetryExitCodes, c)
+		}
+	}
+
+	if *retryOnStderr != "" {
+		re, err := regexp.Compile(*retryOnStderr)
+		if err != nil {
+			l.Fatalf("ERROR -retry-on-stderr: %v\n", err)
+		}
+		o.RetryStderr = re
+	}
+
+	if *useTemplate { // Parse up front so template errors stop the run before any node starts
+		o.Templates, err = ParseTemplates(command)
+		if err != nil {
+			l.Fatalf("ERROR -template: %v\n", err)
+		}
+	}
+
+	var batchSizes []BatchSize
+	if *batch != "" {
+		batchSizes, err = ParseBatchSizes(*batch)
+		if err != nil {
+			l.Fatalf("ERROR -batch: %v\n", err)
+		}
+	}
+
+	var s *Scheduler
+	var tripped sync.Once
+	var matched atomic.Int64 // Set once the inventory is fully read, as percentages are of all matched nodes
+	checkFailures := func() {
+		if summary.Exceeds(*maxFailures, *maxFailurePercent, int(matched.Load())) {
+			tripped.Do(func() { // Circuit breaker, stop starting nodes
+				s.Stop()
+				l.Printf("ERROR Failure limit reached, no further nodes will be started\n")
+				if *abortRunning {
+					cancel()
+				}
+			})
+		}
+	}
+	s = NewScheduler(*parallel, groupLimits, func(n Node) {
+		r := n.Process(ctx, &command, &o, nl)
+		summary.Add(r)
+
+		if r.Failed() {
+			checkFailures()
+		}
+
+		if w != nil {
+			if err := w.Write(r); err != nil {
+				l.Printf("ERROR %X: %v\n", n.ID, err)
+			}
+		}
+	})
+
+	go func() { // Stop starting nodes once the run is cancelled or past its deadline
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	/* Forward SIGINT/SIGTERM to running commands, killing their process
+	groups after the grace period or on a second signal, including those of
+	commands which exited meanwhile leaving children behind */
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	signalled, killed := make(chan struct{}), make(chan struct{})
+	go func() {
+		sig := <-signals
+		close(signalled)
+		s.Stop()
+		l.Printf("ERROR Received %v, signalled %d running commands\n", sig, SignalCommands(sig))
+
+		select {
+		case <-signals:
+		case <-time.After(*grace):
+		}
+		KillCommands()
+		cancel()
+		close(killed)
+	}()
+
+	if batchSizes == nil && !*dryRun && !*confirm {
+		schedule(filter, s.Submit)
+		matched.Store(int64(s.Submitted()))
+		checkFailures()
+	} else { // Plans and batches cover all matched nodes, so collect them first
+		var nodes []Node
+		schedule(filter, func(n Node) { nodes = append(nodes, n) })
+
+		var plans []Plan
+		if *dryRun || *confirm {
+			for _, n := range nodes {
+				plans = append(plans, NewPlan(n, &command, &o))
+			}
+
+			planFormat, planOut := *output, io.Writer(os.Stdout)
+			if *confirm && *output == "jsonl" { // Keep stdout for results
+				planFormat, planOut = "text", os.Stderr
+			}
+			if err := WritePlans(planOut, plans, planFormat); err != nil {
+				l.Fatalf("ERROR %v\n", err)
+			}
+		}
+
+		if *dryRun { // Render failures fail the plan, so checks can catch them
+			failed := 0
+			for _, p := range plans {
+				if p.Error != "" {
+					failed++
+				}
+			}
+			if failed > 0 {
+				l.Fatalf("ERROR %d of %d nodes could not be rendered\n", failed, len(plans))
+			}
+			os.Exit(inventoryExitCode(inventoryErrors.Count(), l))
+		}
+
+		// Stdin may hold the inventory, so ask on the terminal then
+		in := os.Stdin
+		if *confirm && *inventory == "-" {
+			tty, err := os.Open(terminalPath)
+			if err != nil {
+				l.Fatalf("ERROR -confirm: %v\n", err)
+			}
+			defer tty.Close()
+			in = tty
+		}
+
+		if *confirm && !Confirm(in, os.Stderr, fmt.Sprintf("Run on %d nodes?", len(nodes))) {
+			l.Fatalf("ERROR Not confirmed, no nodes started\n")
+		}
+
+		matched.Store(int64(len(nodes)))
+		if batchSizes == nil {
+			for _, n := range nodes {
+				s.Submit(n)
+			}
+		} else {
+			RunBatches(ctx, SplitBatches(nodes, batchSizes), s, *batchPause, *batchCheck, l)
+		}
+	}
+
+	s.Wait() // Wait for all scheduled nodes to be processed
+
+	select {
+	case <-signalled: // Children of exited commands may still be running
+		<-killed
+	default:
+	}
+
+	summary.Skip(s.Skipped())
+	summary.InventoryErrors = inventoryErrors.Count()
+	summary.Report(l, strings.Split(*summaryKeys, ","))
 
-	wg.Wait() // Wait for all goroutines to cleanup and exit
+	cancel()
+	os.Exit(summary.ExitCode(policy))
 }
@@ -0,0 +1,13 @@
+#!/bin/sh
+# Ansible style dynamic inventory fixture
+if [ "$1" != "--list" ]; then
+	echo "Expected --list" >&2
+	exit 1
+fi
+
+cat <<'JSON'
+{
+  "web": {"hosts": ["web-01", "web-02"], "vars": {"port": 8080}},
+  "_meta": {"hostvars": {"web-01": {"address": "10.0.0.1"}}}
+}
+JSON